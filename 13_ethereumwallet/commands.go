@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/term"
+)
+
+// keyOptions selects a keystore account
+type keyOptions struct {
+	dir  *string
+	name *string
+}
+
+func addKeyFlags(fs *flag.FlagSet) *keyOptions {
+	return &keyOptions{
+		dir:  fs.String("keystore", "keystore", "Directory of encrypted account key files"),
+		name: fs.String("name", "", "Keystore account name"),
+	}
+}
+
+func (o *keyOptions) open() (*Keystore, error) {
+	if *o.name == "" {
+		return nil, errors.New("-name is required")
+	}
+	return OpenKeystore(*o.dir)
+}
+
+// load prompts for the account's passphrase and decrypts its key
+func (o *keyOptions) load() (*ecdsa.PrivateKey, error) {
+	ks, err := o.open()
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := readPassphrase("Passphrase for " + *o.name + ": ")
+	if err != nil {
+		return nil, err
+	}
+	account := AccountKey{Name: *o.name}
+	if err := account.LoadKey(ks, passphrase); err != nil {
+		return nil, err
+	}
+	return account.Key, nil
+}
+
+// mnemonicOptions selects the wordlist and BIP-39 passphrase of a mnemonic
+type mnemonicOptions struct {
+	language   *string
+	passphrase *bool
+}
+
+func addMnemonicFlags(fs *flag.FlagSet) *mnemonicOptions {
+	return &mnemonicOptions{
+		language:   fs.String("language", "english", "Mnemonic wordlist language"),
+		passphrase: fs.Bool("bip39-passphrase", false, "Prompt for a BIP-39 passphrase (\"25th word\") protecting the mnemonic"),
+	}
+}
+
+// setup selects the wordlist and returns the BIP-39 passphrase, which is
+// empty unless -bip39-passphrase is set. A new passphrase is confirmed.
+func (o *mnemonicOptions) setup(isNew bool) (string, error) {
+	if err := setLanguage(*o.language); err != nil {
+		return "", err
+	}
+	if !*o.passphrase {
+		return "", nil
+	}
+	if isNew {
+		return readNewSecret(mnemonicPassphraseEnv, "BIP-39 passphrase: ")
+	}
+	return readSecret(mnemonicPassphraseEnv, "BIP-39 passphrase: ")
+}
+
+// pathOptions selects a BIP-44 derivation path
+type pathOptions struct {
+	account *uint
+	index   *uint
+	path    *string
+}
+
+func addPathFlags(fs *flag.FlagSet) *pathOptions {
+	return &pathOptions{
+		account: fs.Uint("account", 0, "BIP-44 account number"),
+		index:   fs.Uint("index", 0, "BIP-44 address index"),
+		path:    fs.String("path", "", "Derivation path overriding -account and -index, e.g. m/44'/60'/0'/0/0"),
+	}
+}
+
+func (o *pathOptions) String() string {
+	if *o.path != "" {
+		return *o.path
+	}
+	return accountPath(uint32(*o.account), uint32(*o.index))
+}
+
+func cmdNew(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	paths := addPathFlags(fs)
+	mnemonicOpts := addMnemonicFlags(fs)
+	words := fs.Int("words", 24, "Number of mnemonic words: 12, 15, 18, 21 or 24")
+	showMnemonic := fs.Bool("show-mnemonic", false, "Print the generated mnemonic so it can be written down")
+	fs.Parse(args)
+
+	passphrase, err := mnemonicOpts.setup(true)
+	if err != nil {
+		return err
+	}
+	mnemonic, err := generateMnemonic(*words)
+	if err != nil {
+		return err
+	}
+	if err := saveMnemonicKey(keys, paths, *mnemonic, passphrase); err != nil {
+		return err
+	}
+	if *showMnemonic {
+		fmt.Println("Mnemonic:", *mnemonic)
+		fmt.Fprintln(os.Stderr, "Write the mnemonic down: it's the only way to recover the key without the keystore and its passphrase")
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "The mnemonic wasn't printed, so the keystore file is the only copy of the key: back it up and keep its passphrase, or use -show-mnemonic next time")
+	return nil
+}
+
+func cmdImportMnemonic(args []string) error {
+	fs := flag.NewFlagSet("import-mnemonic", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	paths := addPathFlags(fs)
+	mnemonicOpts := addMnemonicFlags(fs)
+	fs.Parse(args)
+
+	mnemonic, err := readMnemonic()
+	if err != nil {
+		return err
+	}
+	if err := setLanguage(*mnemonicOpts.language); err != nil {
+		return err
+	}
+	if err := validateMnemonic(mnemonic); err != nil {
+		return err
+	}
+	passphrase, err := mnemonicOpts.setup(false)
+	if err != nil {
+		return err
+	}
+	return saveMnemonicKey(keys, paths, mnemonic, passphrase)
+}
+
+// saveMnemonicKey derives a key from mnemonic and its BIP-39 passphrase and
+// saves it in the keystore
+func saveMnemonicKey(keys *keyOptions, paths *pathOptions, mnemonic, mnemonicPassphrase string) error {
+	ks, err := keys.open()
+	if err != nil {
+		return err
+	}
+	privateKey, publicKey, path, err := hdWallet(mnemonic, mnemonicPassphrase, paths.String()) // Verify: https://iancoleman.io/bip39/
+	if err != nil {
+		return err
+	}
+	passphrase, err := readNewPassphrase("New passphrase for " + *keys.name + ": ")
+	if err != nil {
+		return err
+	}
+	account := AccountKey{Name: *keys.name, Key: privateKey}
+	if err := account.SaveKey(ks, passphrase); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s (%s) as %s\n", crypto.PubkeyToAddress(*publicKey).Hex(), *path, *keys.name)
+	return nil
+}
+
+func cmdImportKey(args []string) error {
+	fs := flag.NewFlagSet("import-key", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	in := fs.String("in", "", "JSON key file to import")
+	fs.Parse(args)
+
+	ks, err := keys.open()
+	if err != nil {
+		return err
+	}
+	keyJSON, err := ioutil.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase("Passphrase of " + *in + ": ")
+	if err != nil {
+		return err
+	}
+	account, err := ks.Import(*keys.name, keyJSON, passphrase, passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s as %s\n", account.Address.Hex(), *keys.name)
+	return nil
+}
+
+func cmdExportKey(args []string) error {
+	fs := flag.NewFlagSet("export-key", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	out := fs.String("out", "", "File to write the JSON key to")
+	fs.Parse(args)
+
+	ks, err := keys.open()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+	passphrase, err := readPassphrase("Passphrase for " + *keys.name + ": ")
+	if err != nil {
+		return err
+	}
+	keyJSON, err := ks.Export(*keys.name, passphrase, passphrase)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*out, keyJSON, 0600)
+}
+
+func cmdList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	dir := fs.String("keystore", "keystore", "Directory of encrypted account key files")
+	fs.Parse(args)
+
+	ks, err := OpenKeystore(*dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range ks.Names() {
+		account, err := ks.Account(name)
+		if err != nil {
+			fmt.Printf("%s\t%v\n", name, err)
+			continue
+		}
+		fmt.Printf("%s\t%s\n", name, account.Address.Hex())
+	}
+	return nil
+}
+
+func cmdDeleteKey(args []string) error {
+	fs := flag.NewFlagSet("delete-key", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	fs.Parse(args)
+
+	ks, err := keys.open()
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase("Passphrase for " + *keys.name + ": ")
+	if err != nil {
+		return err
+	}
+	if err := ks.Delete(*keys.name, passphrase); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %s\n", *keys.name)
+	return nil
+}
+
+func cmdAddress(args []string) error {
+	fs := flag.NewFlagSet("address", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	mnemonicOpts := addMnemonicFlags(fs)
+	account := fs.Uint("account", 0, "BIP-44 account number to list addresses of")
+	basePath := fs.String("path", "", "Derivation path to list addresses under, overriding -account, e.g. m/44'/60'/1'/0")
+	list := fs.Int("list", 0, "List the first K addresses of a mnemonic read from stdin")
+	showKey := fs.Bool("show-key", false, "Decrypt and print the account's keys")
+	fs.Parse(args)
+
+	if *list > 0 {
+		path := *basePath
+		if path == "" {
+			path = fmt.Sprintf(defaultAccountPath, *account)
+		}
+		if _, err := parseDerivationPath(path); err != nil {
+			return err
+		}
+		mnemonic, err := readMnemonic()
+		if err != nil {
+			return err
+		}
+		passphrase, err := mnemonicOpts.setup(false)
+		if err != nil {
+			return err
+		}
+		addresses, err := listAddresses(mnemonic, passphrase, path, *list)
+		if err != nil {
+			return err
+		}
+		for _, a := range addresses {
+			fmt.Printf("%s\t%s\n", a.Path, a.Address)
+		}
+		return nil
+	}
+
+	if !*showKey {
+		ks, err := keys.open()
+		if err != nil {
+			return err
+		}
+		acc, err := ks.Account(*keys.name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(acc.Address.Hex())
+		return nil
+	}
+	privateKey, err := keys.load()
+	if err != nil {
+		return err
+	}
+	fmt.Println("ETH Wallet Address: ", crypto.PubkeyToAddress(privateKey.PublicKey).Hex())
+	fmt.Println("ETH Public Key: ", hexutil.Encode(crypto.FromECDSAPub(&privateKey.PublicKey)[1:])) // As Ethereum does not DER encode its public keys, public keys in Ethereum are only 64 bytes long.
+	fmt.Println("ETH Private Key: ", hexutil.Encode(crypto.FromECDSA(privateKey)))
+	return nil
+}
+
+func cmdBalance(args []string) error {
+	fs := flag.NewFlagSet("balance", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	address := fs.String("address", "", "Address to show the balance of, instead of -name")
+	net := addNetworkFlags(fs)
+	fs.Parse(args)
+
+	addr, err := resolveAddress(keys, *address)
+	if err != nil {
+		return err
+	}
+	client, n, err := net.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	balance, err := client.BalanceAt(context.Background(), addr, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println(n.Symbol+" Balance: ", formatAmount(balance, etherDecimals))
+	return nil
+}
+
+// resolveAddress returns address if given, and otherwise the address of the
+// selected keystore account
+func resolveAddress(keys *keyOptions, address string) (common.Address, error) {
+	if address != "" {
+		return parseAddress("account", address)
+	}
+	ks, err := keys.open()
+	if err != nil {
+		return common.Address{}, err
+	}
+	acc, err := ks.Account(*keys.name)
+	return acc.Address, err
+}
+
+// txOptions are the flags of commands that sign a transaction
+type txOptions struct {
+	keys     *keyOptions
+	gasLimit *uint64
+	txType   *string
+	network  *networkOptions
+
+	// Offline signing, without a node
+	offline     *bool
+	nonce       *uint64
+	gasPrice    *string
+	maxFee      *string
+	priorityFee *string
+	chainID     *uint64
+}
+
+func addTxFlags(fs *flag.FlagSet) *txOptions {
+	return &txOptions{
+		keys:        addKeyFlags(fs),
+		gasLimit:    fs.Uint64("gas-limit", 0, "Gas limit; estimated by the node if 0"),
+		txType:      fs.String("type", "dynamic", "Transaction type: dynamic (EIP-1559) or legacy"),
+		network:     addNetworkFlags(fs),
+		offline:     fs.Bool("offline", false, "Sign without a node, using -nonce, -chain-id and fee flags"),
+		nonce:       fs.Uint64("nonce", 0, "Account nonce, for -offline"),
+		gasPrice:    fs.String("gas-price", "", "Gas price in gwei, for -offline legacy transactions"),
+		maxFee:      fs.String("max-fee", "", "Max fee per gas in gwei, for -offline dynamic-fee transactions"),
+		priorityFee: fs.String("priority-fee", "", "Priority fee per gas in gwei, for -offline dynamic-fee transactions"),
+		chainID:     fs.Uint64("chain-id", 0, "Chain ID overriding the network profile's, for -offline"),
+	}
+}
+
+// dial connects to the network's node, unless signing offline when it only
+// returns the network profile
+func (o *txOptions) dial() (*ethclient.Client, *network, error) {
+	if *o.offline {
+		n, err := o.network.profile()
+		return nil, n, err
+	}
+	return o.network.dial()
+}
+
+// sign builds a transaction on network n and signs it. Parameters are
+// fetched from the node unless signing offline, when client is unused.
+func (o *txOptions) sign(client txBackend, n *network, to common.Address, value *big.Int, data []byte) ([]byte, error) {
+	p := &txParams{To: to, Value: value, Data: data, GasLimit: *o.gasLimit}
+	switch *o.txType {
+	case "dynamic":
+		p.Dynamic = true
+	case "legacy":
+	default:
+		return nil, fmt.Errorf("unknown transaction type %q, want dynamic or legacy", *o.txType)
+	}
+	if *o.offline {
+		if err := o.offlineParams(p, n); err != nil {
+			return nil, err
+		}
+	}
+	privateKey, err := o.keys.load()
+	if err != nil {
+		return nil, err
+	}
+	if !*o.offline {
+		if err := fillTxParams(context.Background(), client, crypto.PubkeyToAddress(privateKey.PublicKey), p); err != nil {
+			return nil, err
+		}
+	}
+	signedTx, err := signTx(p, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Signed %s: nonce %d, %s %s to %s, gas %d at %s, chain %s\n",
+		signedTx.Hash().Hex(), p.Nonce, formatAmount(p.Value, etherDecimals), n.Symbol, p.To.Hex(),
+		p.GasLimit, describeFees(p), p.ChainID)
+	return encodeTx(signedTx)
+}
+
+// offlineParams sets the parameters a node would otherwise supply from flags
+// and the network profile n
+func (o *txOptions) offlineParams(p *txParams, n *network) error {
+	p.Nonce = *o.nonce
+	p.ChainID = new(big.Int).SetUint64(n.ChainID)
+	if *o.chainID != 0 {
+		p.ChainID.SetUint64(*o.chainID)
+	}
+	if p.GasLimit == 0 {
+		if len(p.Data) > 0 {
+			return errors.New("-offline requires -gas-limit for contract calls")
+		}
+		p.GasLimit = transferGasLimit
+	}
+
+	var err error
+	if !p.Dynamic {
+		if *o.gasPrice == "" {
+			return errors.New("-offline requires -gas-price for legacy transactions")
+		}
+		p.GasPrice, err = parseAmount(*o.gasPrice, gweiDecimals)
+		return err
+	}
+	if *o.maxFee == "" || *o.priorityFee == "" {
+		return errors.New("-offline requires -max-fee and -priority-fee for dynamic-fee transactions")
+	}
+	if p.GasFeeCap, err = parseAmount(*o.maxFee, gweiDecimals); err != nil {
+		return err
+	}
+	p.GasTipCap, err = parseAmount(*o.priorityFee, gweiDecimals)
+	return err
+}
+
+// transferOptions describes an ETH transfer
+type transferOptions struct {
+	*txOptions
+	to    *string
+	value *string
+}
+
+func addTransferFlags(fs *flag.FlagSet) *transferOptions {
+	return &transferOptions{
+		txOptions: addTxFlags(fs),
+		to:        fs.String("to", "", "Recipient address"),
+		value:     fs.String("value", "0", "Amount of ETH to send"),
+	}
+}
+
+// signTransfer builds the transfer on network n and signs it
+func (o *transferOptions) signTransfer(client txBackend, n *network) ([]byte, error) {
+	to, err := parseAddress("recipient", *o.to)
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseAmount(*o.value, etherDecimals)
+	if err != nil {
+		return nil, err
+	}
+	return o.sign(client, n, to, value, nil)
+}
+
+// parseAddress parses a hex address, naming what it is in errors
+func parseAddress(what, s string) (common.Address, error) {
+	if !common.IsHexAddress(s) {
+		return common.Address{}, fmt.Errorf("invalid %s address %q", what, s)
+	}
+	return common.HexToAddress(s), nil
+}
+
+func cmdSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	transfer := addTransferFlags(fs)
+	wait := addWaitFlags(fs)
+	fs.Parse(args)
+
+	if *transfer.offline {
+		return errors.New("send needs a node; use sign-tx -offline and broadcast")
+	}
+	client, n, err := transfer.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	raw, err := transfer.signTransfer(client, n)
+	if err != nil {
+		return err
+	}
+	tx, err := broadcast(client, n, raw)
+	if err != nil {
+		return err
+	}
+	return wait.track(client, tx)
+}
+
+func cmdSignTx(args []string) error {
+	fs := flag.NewFlagSet("sign-tx", flag.ExitOnError)
+	transfer := addTransferFlags(fs)
+	out := fs.String("out", "", "File to write the raw transaction to instead of stdout")
+	qr := fs.String("qr", "", "PNG file to write the raw transaction to as a QR code, or - for the terminal")
+	fs.Parse(args)
+
+	client, n, err := transfer.dial()
+	if err != nil {
+		return err
+	}
+	if client != nil {
+		defer client.Close()
+	}
+	raw, err := transfer.signTransfer(client, n)
+	if err != nil {
+		return err
+	}
+	return writeRawTx(raw, *out, *qr)
+}
+
+// writeRawTx writes a hex raw transaction to out, or stdout if empty, and
+// as a QR code if qr is set
+func writeRawTx(raw []byte, out, qr string) error {
+	rawHex := hex.EncodeToString(raw)
+	switch qr {
+	case "":
+	case "-":
+		code, err := qrcode.New(rawHex, qrcode.Low)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(os.Stderr, code.ToSmallString(false))
+	default:
+		if err := qrcode.WriteFile(rawHex, qrcode.Low, 512, qr); err != nil {
+			return err
+		}
+	}
+	if out != "" {
+		return ioutil.WriteFile(out, []byte(rawHex+"\n"), 0600)
+	}
+	fmt.Println(rawHex)
+	return nil
+}
+
+func cmdBroadcast(args []string) error {
+	fs := flag.NewFlagSet("broadcast", flag.ExitOnError)
+	in := fs.String("in", "", "File with the hex raw transaction; stdin if empty")
+	net := addNetworkFlags(fs)
+	wait := addWaitFlags(fs)
+	fs.Parse(args)
+
+	rawHex, err := readInput(*in)
+	if err != nil {
+		return err
+	}
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(rawHex)), "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid raw transaction: %w", err)
+	}
+	client, n, err := net.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	tx, err := broadcast(client, n, raw)
+	if err != nil {
+		return err
+	}
+	return wait.track(client, tx)
+}
+
+// broadcast sends a raw signed transaction on network n
+func broadcast(sender txSender, n *network, raw []byte) (*types.Transaction, error) {
+	tx, err := sendRawTx(context.Background(), sender, raw)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("TX sent: %s\n", tx.Hash().Hex())
+	if url := n.txURL(tx.Hash().Hex()); url != "" {
+		fmt.Println(url)
+	}
+	return tx, nil
+}
+
+// readMnemonic prompts for a mnemonic without echoing it, or reads it from
+// the first line of stdin if that isn't a terminal
+func readMnemonic() (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Mnemonic: ")
+		m, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return strings.Join(strings.Fields(string(m)), " "), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no mnemonic on stdin")
+	}
+	return strings.Join(strings.Fields(line), " "), nil
+}
+
+// readInput reads the named file, or stdin if name is empty
+func readInput(name string) ([]byte, error) {
+	if name == "" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(name)
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+// An encrypted stream is a header with a random AES-256 key encrypted to
+// the recipient with ECIES, then the plaintext in AES-GCM sealed chunks.
+// Each chunk is framed as a final flag byte, which is also its additional
+// data, and a big-endian uint32 length. Chunk i is sealed with nonce i, so
+// chunks can't be reordered, and the final flag catches truncation.
+const (
+	streamMagic   = "ETHECIES"
+	streamVersion = 1
+	streamChunk   = 64 * 1024
+
+	armorBegin = "-----BEGIN ETH ECIES MESSAGE-----"
+	armorEnd   = "-----END ETH ECIES MESSAGE-----"
+	armorWidth = 64
+)
+
+// parsePublicKey parses a hex secp256k1 public key, either 65 bytes starting
+// with 0x04 or the 64 bytes Ethereum uses without it
+func parsePublicKey(s string) (*ecdsa.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "0x") {
+		s = "0x" + s
+	}
+	b, err := hexutil.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(b) == 64 {
+		b = append([]byte{4}, b...)
+	}
+	return crypto.UnmarshalPubkey(b)
+}
+
+// recoverPublicKey returns the public key that signed tx. It's how to find
+// the public key of an address that has sent a transaction.
+func recoverPublicKey(tx *types.Transaction) (*ecdsa.PublicKey, error) {
+	signer := types.LatestSignerForChainID(tx.ChainId())
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return nil, err
+	}
+	_, r, s := tx.RawSignatureValues()
+	sig := make([]byte, crypto.SignatureLength)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:64])
+	hash := signer.Hash(tx)
+	for v := byte(0); v <= 1; v++ {
+		sig[64] = v
+		if pub, err := crypto.SigToPub(hash[:], sig); err == nil && crypto.PubkeyToAddress(*pub) == from {
+			return pub, nil
+		}
+	}
+	return nil, errors.New("can't recover the transaction's public key")
+}
+
+// encryptMessage encrypts data to pub with ECIES
+func encryptMessage(pub *ecdsa.PublicKey, data []byte) ([]byte, error) {
+	return ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), data, nil, nil)
+}
+
+// decryptMessage decrypts ECIES ciphertext with key
+func decryptMessage(key *ecdsa.PrivateKey, data []byte) ([]byte, error) {
+	return ecies.ImportECDSA(key).Decrypt(data, nil, nil)
+}
+
+func newStreamCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, i uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], i)
+	return nonce
+}
+
+// encryptStream encrypts everything read from r to pub, writing it to w
+// a chunk at a time
+func encryptStream(w io.Writer, r io.Reader, pub *ecdsa.PublicKey) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := encryptMessage(pub, key)
+	if err != nil {
+		return err
+	}
+	aead, err := newStreamCipher(key)
+	if err != nil {
+		return err
+	}
+
+	header := append([]byte(streamMagic), streamVersion, 0, 0)
+	binary.BigEndian.PutUint16(header[len(header)-2:], uint16(len(wrapped)))
+	if _, err := w.Write(append(header, wrapped...)); err != nil {
+		return err
+	}
+
+	// Read a chunk ahead, to know which chunk is the last
+	cur, next := make([]byte, streamChunk), make([]byte, streamChunk)
+	n, eof, err := readChunk(r, cur)
+	for i := uint64(0); ; i++ {
+		if err != nil {
+			return err
+		}
+		var m int
+		var nextEOF bool
+		if !eof {
+			if m, nextEOF, err = readChunk(r, next); err != nil {
+				return err
+			}
+		}
+		final := eof || (nextEOF && m == 0)
+		frame := make([]byte, 5)
+		if final {
+			frame[0] = 1
+		}
+		sealed := aead.Seal(nil, chunkNonce(aead, i), cur[:n], frame[:1])
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+		if _, err := w.Write(append(frame, sealed...)); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		cur, next, n, eof = next, cur, m, nextEOF
+	}
+}
+
+// readChunk fills buf from r, reporting whether r ended
+func readChunk(r io.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	return n, false, err
+}
+
+// decryptStream decrypts a stream written by encryptStream with key,
+// writing the plaintext to w as each chunk is authenticated. If it fails,
+// what was written must be discarded.
+func decryptStream(w io.Writer, r io.Reader, key *ecdsa.PrivateKey) error {
+	header := make([]byte, len(streamMagic)+3)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(streamMagic)]) != streamMagic {
+		return errors.New("not an encrypted message")
+	}
+	if v := header[len(streamMagic)]; v != streamVersion {
+		return fmt.Errorf("unsupported message version %d", v)
+	}
+	wrapped := make([]byte, binary.BigEndian.Uint16(header[len(header)-2:]))
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return errors.New("truncated message header")
+	}
+	streamKey, err := decryptMessage(key, wrapped)
+	if err != nil {
+		return fmt.Errorf("message isn't encrypted to this key: %w", err)
+	}
+	aead, err := newStreamCipher(streamKey)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 5)
+	buf := make([]byte, streamChunk+aead.Overhead())
+	for i := uint64(0); ; i++ {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return errors.New("message is truncated")
+		}
+		size := binary.BigEndian.Uint32(frame[1:])
+		if frame[0] > 1 || size > uint32(len(buf)) {
+			return fmt.Errorf("corrupt chunk %d", i)
+		}
+		if _, err := io.ReadFull(r, buf[:size]); err != nil {
+			return errors.New("message is truncated")
+		}
+		plain, err := aead.Open(buf[:0], chunkNonce(aead, i), buf[:size], frame[:1])
+		if err != nil {
+			return fmt.Errorf("chunk %d failed authentication", i)
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if frame[0] == 1 {
+			switch _, err := io.ReadFull(r, frame[:1]); err {
+			case io.EOF:
+				return nil
+			case nil:
+				return errors.New("unexpected data after the message")
+			default:
+				return err
+			}
+		}
+	}
+}
+
+// armorWriter base64 encodes what's written to it between armor lines
+type armorWriter struct {
+	w       io.Writer
+	lines   *lineWriter
+	encoder io.WriteCloser
+}
+
+func newArmorWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := io.WriteString(w, armorBegin+"\n"); err != nil {
+		return nil, err
+	}
+	lines := &lineWriter{w: w}
+	return &armorWriter{w: w, lines: lines, encoder: base64.NewEncoder(base64.StdEncoding, lines)}, nil
+}
+
+func (a *armorWriter) Write(p []byte) (int, error) {
+	return a.encoder.Write(p)
+}
+
+// Close flushes the encoding and writes the end line. It doesn't close the
+// underlying writer.
+func (a *armorWriter) Close() error {
+	if err := a.encoder.Close(); err != nil {
+		return err
+	}
+	end := armorEnd + "\n"
+	if a.lines.col > 0 {
+		end = "\n" + end
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
+
+// lineWriter breaks what's written to it into armorWidth lines
+type lineWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := armorWidth - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+		if l.col += n; l.col == armorWidth {
+			if _, err := l.w.Write([]byte{'\n'}); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
+
+// newArmorReader decodes an armored message read from br
+func newArmorReader(br *bufio.Reader) (io.Reader, error) {
+	line, err := br.ReadString('\n')
+	if strings.TrimSpace(line) != armorBegin {
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, errors.New("missing armor begin line")
+	}
+	return base64.NewDecoder(base64.StdEncoding, &armorBody{br: br}), nil
+}
+
+// armorBody reads the base64 lines of an armored message, without line
+// breaks, up to its end line
+type armorBody struct {
+	br   *bufio.Reader
+	line string
+	done bool
+	err  error
+}
+
+func (a *armorBody) Read(p []byte) (int, error) {
+	for a.line == "" {
+		if a.done {
+			return 0, io.EOF
+		}
+		if a.err != nil {
+			return 0, a.err
+		}
+		line, err := a.br.ReadString('\n')
+		if err == io.EOF {
+			a.err = errors.New("missing armor end line")
+		} else if err != nil {
+			a.err = err
+		}
+		a.line = strings.TrimSpace(line)
+		if a.line == armorEnd {
+			a.line, a.done = "", true
+		}
+	}
+	n := copy(p, a.line)
+	a.line = a.line[n:]
+	return n, nil
+}
+
+// openOutput creates the named file, or returns stdout if name is empty.
+// remove deletes a partly written file after an error.
+func openOutput(name string) (w io.WriteCloser, remove func(), err error) {
+	if name == "" {
+		return nopCloser{os.Stdout}, func() {}, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close(); os.Remove(name) }, nil
+}
+
+// openInput opens the named file, or returns stdin if name is empty
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func cmdEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	pubkey := fs.String("pubkey", "", "Recipient's hex public key")
+	to := fs.String("to", "", "Recipient address, whose public key is recovered from the transaction -tx")
+	txHash := fs.String("tx", "", "Hash of a transaction sent by -to")
+	net := addNetworkFlags(fs)
+	in := fs.String("in", "", "File to encrypt; stdin if empty")
+	out := fs.String("out", "", "File to write the encrypted message to; stdout if empty")
+	armor := fs.Bool("armor", false, "Write the message as armored text")
+	fs.Parse(args)
+
+	pub, err := recipientKey(keys, *pubkey, *to, *txHash, net)
+	if err != nil {
+		return err
+	}
+	r, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, remove, err := openOutput(*out)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		if !*armor {
+			return encryptStream(w, r, pub)
+		}
+		aw, err := newArmorWriter(w)
+		if err != nil {
+			return err
+		}
+		if err := encryptStream(aw, r, pub); err != nil {
+			return err
+		}
+		return aw.Close()
+	}()
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		remove()
+	}
+	return err
+}
+
+// recipientKey returns the public key given as hex, recovered from a
+// transaction sent by an address, or of a keystore account
+func recipientKey(keys *keyOptions, pubkey, to, txHash string, net *networkOptions) (*ecdsa.PublicKey, error) {
+	switch {
+	case pubkey != "":
+		return parsePublicKey(pubkey)
+	case to != "":
+		address, err := parseAddress("recipient", to)
+		if err != nil {
+			return nil, err
+		}
+		if txHash == "" {
+			return nil, errors.New("-to requires -tx, a transaction the recipient sent")
+		}
+		client, _, err := net.dial()
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+		tx, _, err := client.TransactionByHash(context.Background(), common.HexToHash(txHash))
+		if err != nil {
+			return nil, fmt.Errorf("looking up %s: %w", txHash, err)
+		}
+		pub, err := recoverPublicKey(tx)
+		if err != nil {
+			return nil, err
+		}
+		if crypto.PubkeyToAddress(*pub) != address {
+			return nil, fmt.Errorf("%s wasn't sent by %s", txHash, address.Hex())
+		}
+		return pub, nil
+	case *keys.name != "":
+		privateKey, err := keys.load()
+		if err != nil {
+			return nil, err
+		}
+		return &privateKey.PublicKey, nil
+	}
+	return nil, errors.New("one of -pubkey, -to or -name is required")
+}
+
+func cmdDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	in := fs.String("in", "", "Encrypted message, binary or armored; stdin if empty")
+	out := fs.String("out", "", "File to write the plaintext to; stdout if empty")
+	fs.Parse(args)
+
+	privateKey, err := keys.load()
+	if err != nil {
+		return err
+	}
+	f, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if prefix, _ := br.Peek(len(armorBegin)); string(prefix) == armorBegin {
+		if r, err = newArmorReader(br); err != nil {
+			return err
+		}
+	}
+	w, remove, err := openOutput(*out)
+	if err != nil {
+		return err
+	}
+	err = decryptStream(w, r, privateKey)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		remove()
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestStream(t *testing.T) {
+	key := newTestKey(t)
+	for _, size := range []int{0, 1, streamChunk - 1, streamChunk, streamChunk + 1, 2 * streamChunk, 3*streamChunk + 7} {
+		for _, armor := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%d bytes armored %v", size, armor), func(t *testing.T) {
+				plain := make([]byte, size)
+				if _, err := rand.Read(plain); err != nil {
+					t.Fatal(err)
+				}
+				var sealed []byte
+				if armor {
+					sealed = encryptArmoredTest(t, plain, &key.PublicKey)
+				} else {
+					sealed = encryptTest(t, plain, &key.PublicKey)
+					// No empty final chunk after a full one
+					chunks := (size + streamChunk - 1) / streamChunk
+					if chunks == 0 {
+						chunks = 1
+					}
+					if want := streamHeaderLen(t, sealed) + size + chunks*(5+16); len(sealed) != want {
+						t.Fatalf("should write %d chunks in %d bytes, got %d bytes", chunks, want, len(sealed))
+					}
+				}
+				got, err := decryptTest(sealed, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Fatalf("decrypted %d bytes, want the %d encrypted", len(got), len(plain))
+				}
+			})
+		}
+	}
+}
+
+func TestStreamDamaged(t *testing.T) {
+	key := newTestKey(t)
+	plain := bytes.Repeat([]byte("x"), 2*streamChunk+100)
+	sealed := encryptTest(t, plain, &key.PublicKey)
+	header := streamHeaderLen(t, sealed)
+	chunk := 5 + streamChunk + 16
+	last := header + 2*chunk
+
+	damage := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), sealed...))
+	}
+	for _, tt := range []struct {
+		name   string
+		sealed []byte
+		err    string
+	}{
+		{name: "empty", sealed: nil, err: "not an encrypted message"},
+		{name: "not encrypted", sealed: []byte(strings.Repeat("plain text ", 10)), err: "not an encrypted message"},
+		{name: "truncated header", sealed: sealed[:header-1], err: "truncated message header"},
+		{name: "no chunks", sealed: sealed[:header], err: "truncated"},
+		{name: "truncated before the final chunk", sealed: sealed[:last], err: "truncated"},
+		{name: "truncated within a chunk", sealed: sealed[:last-1], err: "truncated"},
+		{name: "truncated final chunk", sealed: sealed[:len(sealed)-1], err: "truncated"},
+		{name: "trailing data", sealed: append(append([]byte(nil), sealed...), 0), err: "unexpected data"},
+		{
+			name:   "tampered chunk",
+			sealed: damage(func(b []byte) []byte { b[header+chunk+100] ^= 1; return b }),
+			err:    "chunk 1 failed authentication",
+		},
+		{
+			name:   "final flag set early",
+			sealed: damage(func(b []byte) []byte { b[header+chunk] = 1; return b[:header+2*chunk] }),
+			err:    "chunk 1 failed authentication",
+		},
+		{
+			name:   "final flag cleared",
+			sealed: damage(func(b []byte) []byte { b[last] = 0; return b }),
+			err:    "chunk 2 failed authentication",
+		},
+		{
+			name: "chunks swapped",
+			sealed: damage(func(b []byte) []byte {
+				first := append([]byte(nil), b[header:header+chunk]...)
+				copy(b[header:], b[header+chunk:header+2*chunk])
+				copy(b[header+chunk:], first)
+				return b
+			}),
+			err: "chunk 0 failed authentication",
+		},
+		{
+			name:   "corrupt chunk length",
+			sealed: damage(func(b []byte) []byte { b[header+1] = 0xff; return b }),
+			err:    "corrupt chunk 0",
+		},
+		{
+			name:   "unsupported version",
+			sealed: damage(func(b []byte) []byte { b[len(streamMagic)] = streamVersion + 1; return b }),
+			err:    "unsupported message version",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptTest(tt.sealed, key)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("should fail with %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if _, err := decryptTest(sealed, newTestKey(t)); err == nil || !strings.Contains(err.Error(), "isn't encrypted to this key") {
+		t.Fatalf("another key shouldn't decrypt the message, got %v", err)
+	}
+}
+
+func TestArmor(t *testing.T) {
+	key := newTestKey(t)
+	plain := bytes.Repeat([]byte("attack at dawn "), 20)
+	armored := string(encryptArmoredTest(t, plain, &key.PublicKey))
+
+	lines := strings.Split(strings.TrimSuffix(armored, "\n"), "\n")
+	if lines[0] != armorBegin || lines[len(lines)-1] != armorEnd {
+		t.Fatalf("should be between armor lines, got %q", armored)
+	}
+	for _, line := range lines[1 : len(lines)-1] {
+		if len(line) == 0 || len(line) > armorWidth {
+			t.Fatalf("body lines should have 1 to %d characters, got %q", armorWidth, line)
+		}
+	}
+
+	// Line endings and surrounding whitespace don't matter
+	crlf := strings.ReplaceAll(armored, "\n", " \r\n")
+	if got, err := decryptTest([]byte(crlf), key); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("should decrypt with CRLF line endings, got %q, %v", got, err)
+	}
+
+	body := strings.Join(lines[1:len(lines)-1], "\n")
+	// The last body line is within the final chunk
+	changed := append([]string(nil), lines...)
+	changed[len(changed)-2] = flipBase64(changed[len(changed)-2][0]) + changed[len(changed)-2][1:]
+	for _, tt := range []struct {
+		name    string
+		armored string
+		err     string
+	}{
+		{name: "no begin line", armored: body + "\n" + armorEnd + "\n", err: "missing armor begin line"},
+		{name: "no end line", armored: armorBegin + "\n" + body + "\n", err: "missing armor end line"},
+		{name: "truncated body", armored: armorBegin + "\n" + body[:len(body)/2] + "\n" + armorEnd + "\n", err: "truncated"},
+		{name: "invalid base64", armored: armorBegin + "\n" + strings.Replace(body, body[10:11], "!", 1) + "\n" + armorEnd + "\n"},
+		{name: "changed character", armored: strings.Join(changed, "\n") + "\n", err: "failed authentication"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptArmoredTest(tt.armored, key)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("should fail with %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func newTestKey(t testing.TB) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// streamHeaderLen returns the length of an encrypted stream's header
+func streamHeaderLen(t testing.TB, sealed []byte) int {
+	t.Helper()
+	if len(sealed) < len(streamMagic)+3 {
+		t.Fatal("the stream should have a header")
+	}
+	n := len(streamMagic) + 3
+	return n + int(binary.BigEndian.Uint16(sealed[n-2:n]))
+}
+
+func encryptTest(t testing.TB, plain []byte, pub *ecdsa.PublicKey) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encryptStream(&buf, bytes.NewReader(plain), pub); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encryptArmoredTest(t testing.TB, plain []byte, pub *ecdsa.PublicKey) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	aw, err := newArmorWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptStream(aw, bytes.NewReader(plain), pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decryptTest decrypts a binary or armored message, as decrypt does
+func decryptTest(sealed []byte, key *ecdsa.PrivateKey) ([]byte, error) {
+	if bytes.HasPrefix(sealed, []byte(armorBegin)) {
+		return decryptArmoredTest(string(sealed), key)
+	}
+	var buf bytes.Buffer
+	err := decryptStream(&buf, bytes.NewReader(sealed), key)
+	return buf.Bytes(), err
+}
+
+func decryptArmoredTest(armored string, key *ecdsa.PrivateKey) ([]byte, error) {
+	r, err := newArmorReader(bufio.NewReader(strings.NewReader(armored)))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = decryptStream(&buf, r, key)
+	return buf.Bytes(), err
+}
+
+// flipBase64 returns a different base64 character than c
+func flipBase64(c byte) string {
+	if c == 'A' {
+		return "B"
+	}
+	return "A"
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// erc20ABI is the part of the ERC-20 interface the wallet uses
+const erc20ABI = `[
+	{"constant":true,"inputs":[{"name":"owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"type":"function"},
+	{"constant":false,"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"type":"function"},
+	{"constant":false,"inputs":[{"name":"spender","type":"address"},{"name":"value","type":"uint256"}],"name":"approve","outputs":[{"name":"","type":"bool"}],"type":"function"}
+]`
+
+var erc20 = mustParseABI(erc20ABI)
+
+func mustParseABI(s string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// contractCaller runs read-only contract calls
+type contractCaller interface {
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// token is an ERC-20 token contract
+type token struct {
+	Address  common.Address
+	Symbol   string
+	Decimals int
+}
+
+// loadToken reads the symbol and decimals of the token at address. Tokens
+// without a string symbol are shown by address.
+func loadToken(ctx context.Context, caller contractCaller, address common.Address) (*token, error) {
+	t := &token{Address: address, Symbol: address.Hex()}
+	out, err := callToken(ctx, caller, address, "decimals")
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't an ERC-20 token: %w", address.Hex(), err)
+	}
+	decimals, ok := out[0].(uint8)
+	if !ok {
+		return nil, fmt.Errorf("unexpected decimals %v", out[0])
+	}
+	t.Decimals = int(decimals)
+	if out, err := callToken(ctx, caller, address, "symbol"); err == nil {
+		if symbol, ok := out[0].(string); ok && symbol != "" {
+			t.Symbol = symbol
+		}
+	}
+	return t, nil
+}
+
+// balanceOf returns owner's balance in the token's base units
+func (t *token) balanceOf(ctx context.Context, caller contractCaller, owner common.Address) (*big.Int, error) {
+	out, err := callToken(ctx, caller, t.Address, "balanceOf", owner)
+	if err != nil {
+		return nil, err
+	}
+	balance, ok := out[0].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("unexpected balance %v", out[0])
+	}
+	return balance, nil
+}
+
+// format formats an amount of base units with the token's decimals
+func (t *token) format(amount *big.Int) string {
+	return formatAmount(amount, t.Decimals) + " " + t.Symbol
+}
+
+func callToken(ctx context.Context, caller contractCaller, address common.Address, method string, args ...interface{}) ([]interface{}, error) {
+	data, err := erc20.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := caller.CallContract(ctx, ethereum.CallMsg{To: &address, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no contract code or empty result")
+	}
+	return erc20.Unpack(method, res)
+}
+
+// tokenOptions describes a transfer or approval of an amount of a token
+type tokenOptions struct {
+	*txOptions
+	token    *string
+	amount   *string
+	decimals *int
+	out      *string
+	wait     *waitOptions
+}
+
+func addTokenFlags(fs *flag.FlagSet) *tokenOptions {
+	return &tokenOptions{
+		txOptions: addTxFlags(fs),
+		token:     fs.String("token", "", "Token contract address"),
+		amount:    fs.String("amount", "", "Amount of tokens"),
+		decimals:  fs.Int("decimals", -1, "Token decimals, for -offline"),
+		out:       fs.String("out", "", "File to write the raw transaction to, for -offline"),
+		wait:      addWaitFlags(fs),
+	}
+}
+
+// run signs a call of method with the address to, the recipient or spender
+// named role, and the amount. It's sent unless signing offline, when the
+// raw transaction is written out.
+func (o *tokenOptions) run(method, role, to string) error {
+	tokenAddress, err := parseAddress("token", *o.token)
+	if err != nil {
+		return err
+	}
+	toAddress, err := parseAddress(role, to)
+	if err != nil {
+		return err
+	}
+	client, n, err := o.dial()
+	if err != nil {
+		return err
+	}
+	if client != nil {
+		defer client.Close()
+	}
+
+	t := &token{Address: tokenAddress, Symbol: tokenAddress.Hex(), Decimals: *o.decimals}
+	if !*o.offline {
+		if t, err = loadToken(context.Background(), client, tokenAddress); err != nil {
+			return err
+		}
+	} else if t.Decimals < 0 {
+		return errors.New("-offline requires -decimals")
+	}
+	amount, err := parseAmount(*o.amount, t.Decimals)
+	if err != nil {
+		return err
+	}
+	data, err := erc20.Pack(method, toAddress, amount)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s %s to %s\n", method, t.format(amount), toAddress.Hex())
+	raw, err := o.sign(client, n, tokenAddress, new(big.Int), data)
+	if err != nil {
+		return err
+	}
+	if *o.offline {
+		return writeRawTx(raw, *o.out, "")
+	}
+	tx, err := broadcast(client, n, raw)
+	if err != nil {
+		return err
+	}
+	return o.wait.track(client, tx)
+}
+
+func cmdTokenBalance(args []string) error {
+	fs := flag.NewFlagSet("token-balance", flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	tokenAddress := fs.String("token", "", "Token contract address")
+	address := fs.String("address", "", "Address to show the balance of, instead of -name")
+	net := addNetworkFlags(fs)
+	fs.Parse(args)
+
+	tokenAddr, err := parseAddress("token", *tokenAddress)
+	if err != nil {
+		return err
+	}
+	owner, err := resolveAddress(keys, *address)
+	if err != nil {
+		return err
+	}
+	client, _, err := net.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx := context.Background()
+	t, err := loadToken(ctx, client, tokenAddr)
+	if err != nil {
+		return err
+	}
+	balance, err := t.balanceOf(ctx, client, owner)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Token Balance: ", t.format(balance))
+	return nil
+}
+
+func cmdTokenTransfer(args []string) error {
+	fs := flag.NewFlagSet("token-transfer", flag.ExitOnError)
+	opts := addTokenFlags(fs)
+	to := fs.String("to", "", "Recipient address")
+	fs.Parse(args)
+	return opts.run("transfer", "recipient", *to)
+}
+
+func cmdTokenApprove(args []string) error {
+	fs := flag.NewFlagSet("token-approve", flag.ExitOnError)
+	opts := addTokenFlags(fs)
+	spender := fs.String("spender", "", "Address allowed to spend the tokens")
+	fs.Parse(args)
+	return opts.run("approve", "spender", *spender)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// testTokenCode is the creation code of a minimal ERC-20 token, TST with 6
+// decimals, that mints 1,000,000 TST to its deployer. It implements the
+// methods in erc20ABI and emits Transfer and Approval; balances are stored
+// at the owner's address and allowances at keccak256(owner, spender).
+const testTokenCode = "0x67000000e8d4a510003355610130806100186000396000f3" +
+	"60003560e01c8063313ce5671461004257806395d89b411461004d57806370a0823114610081" +
+	"578063a9059cbb1461008e578063095ea7b3146100e2575b600080fd5b600660005260206000" +
+	"f35b602060005260036020527f54535400000000000000000000000000000000000000000000" +
+	"0000000000000060405260606000f35b6004355460005260206000f35b335460243580821061" +
+	"003d5780820333556004355481016004355580600052600435337fddf252ad1be2c89b69c2b0" +
+	"68fc378daa952ba7f163c4a11628f55a4df523b3ef60206000a3600160005260206000f35b33" +
+	"600052600435602052602435604060002055602435600052600435337f8c5be1e5ebec7d5bd1" +
+	"4f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b92560206000a3600160005260206000f3"
+
+// testTokenSupply is the number of base units minted by testTokenCode
+var testTokenSupply = big.NewInt(1000000000000)
+
+func TestToken(t *testing.T) {
+	sim, key := newTestChain(t)
+	ctx := context.Background()
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+	tokenAddress := deployTestToken(t, sim, key)
+
+	tk, err := loadToken(ctx, sim, tokenAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Decimals != 6 || tk.Symbol != "TST" {
+		t.Fatalf("should load TST with 6 decimals, got %s with %d", tk.Symbol, tk.Decimals)
+	}
+	balance, err := tk.balanceOf(ctx, sim, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tk.format(balance); balance.Cmp(testTokenSupply) != 0 || got != "1000000 TST" {
+		t.Fatalf("the deployer should have 1000000 TST, got %s", got)
+	}
+
+	// Transfer 12.5 TST
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	amount, err := parseAmount("12.5", tk.Decimals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt := sendTokenTx(t, sim, key, tokenAddress, "transfer", to, amount)
+	if len(receipt.Logs) != 1 || receipt.Logs[0].Topics[0] != erc20Event("Transfer(address,address,uint256)") {
+		t.Fatalf("transfer should log a Transfer event, got %v", receipt.Logs)
+	}
+	for _, want := range []struct {
+		owner   common.Address
+		balance string
+	}{
+		{owner, "999987.5 TST"},
+		{to, "12.5 TST"},
+	} {
+		balance, err := tk.balanceOf(ctx, sim, want.owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tk.format(balance); got != want.balance {
+			t.Fatalf("%s should have %s, got %s", want.owner.Hex(), want.balance, got)
+		}
+	}
+
+	// Approve a spender for 0.000001 TST, the smallest unit
+	spender := common.HexToAddress("0x000000000000000000000000000000000000bEEF")
+	receipt = sendTokenTx(t, sim, key, tokenAddress, "approve", spender, big.NewInt(1))
+	if len(receipt.Logs) != 1 || receipt.Logs[0].Topics[0] != erc20Event("Approval(address,address,uint256)") {
+		t.Fatalf("approve should log an Approval event, got %v", receipt.Logs)
+	}
+	slot := crypto.Keccak256Hash(common.LeftPadBytes(owner.Bytes(), 32), common.LeftPadBytes(spender.Bytes(), 32))
+	allowance, err := sim.StorageAt(ctx, tokenAddress, slot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatAmount(new(big.Int).SetBytes(allowance), tk.Decimals); got != "0.000001" {
+		t.Fatalf("the spender should be allowed 0.000001 TST, got %s", got)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	for _, tt := range []struct {
+		amount   int64
+		decimals int
+		want     string
+	}{
+		{1234500, 6, "1.2345"},
+		{5, 6, "0.000005"},
+		{-250, 2, "-2.5"},
+		{42, 0, "42"},
+		{1000000000, gweiDecimals, "1"},
+	} {
+		if got := formatAmount(big.NewInt(tt.amount), tt.decimals); got != tt.want {
+			t.Errorf("formatAmount(%d, %d) = %s, want %s", tt.amount, tt.decimals, got, tt.want)
+		}
+		if tt.amount < 0 {
+			continue
+		}
+		if n, err := parseAmount(tt.want, tt.decimals); err != nil || n.Int64() != tt.amount {
+			t.Errorf("parseAmount(%s, %d) = %v, %v, want %d", tt.want, tt.decimals, n, err, tt.amount)
+		}
+	}
+}
+
+// deployTestToken deploys testTokenCode from key's account and returns the
+// token's address.
+func deployTestToken(t testing.TB, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey) common.Address {
+	t.Helper()
+	ctx := context.Background()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	nonce, err := sim.PendingNonceAt(ctx, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gasPrice, err := sim.SuggestGasPrice(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID, err := sim.ChainID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := types.SignTx(types.NewContractCreation(nonce, new(big.Int), 500000, gasPrice, hexutil.MustDecode(testTokenCode)),
+		types.NewEIP155Signer(chainID), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.SendTransaction(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	sim.Commit()
+	receipt, err := sim.TransactionReceipt(ctx, tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatal("deploying the test token failed")
+	}
+	return receipt.ContractAddress
+}
+
+// sendTokenTx calls method of the token with the address to and amount in a
+// dynamic-fee transaction signed with key, and returns its receipt.
+func sendTokenTx(t testing.TB, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey, tokenAddress common.Address, method string, to common.Address, amount *big.Int) *types.Receipt {
+	t.Helper()
+	data, err := erc20.Pack(method, to, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sendTestTx(t, sim, key, &txParams{To: tokenAddress, Value: new(big.Int), Data: data, Dynamic: true})
+}
+
+// erc20Event returns the topic of the event with the given signature
+func erc20Event(signature string) common.Hash {
+	return crypto.Keccak256Hash([]byte(signature))
+}
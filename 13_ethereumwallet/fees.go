@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+const (
+	// feeHistoryBlocks is how many recent blocks fee estimates are based on
+	feeHistoryBlocks = 20
+	// feeRewardPercentile is the percentile of each block's priority fees
+	// that estimates are based on
+	feeRewardPercentile = 50
+)
+
+// defaultPriorityFee is the tip paid when recent blocks paid none, 1 gwei
+var defaultPriorityFee = big.NewInt(1000000000)
+
+// feeHistoryReader returns the fee history of recent blocks
+type feeHistoryReader interface {
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+}
+
+// estimateFees returns the priority fee and max fee for a dynamic-fee
+// transaction. The tip is the median of recent blocks' median tips, and the
+// max fee twice the next block's base fee plus the tip, which stays valid
+// through six full blocks of base fee increases.
+func estimateFees(ctx context.Context, backend feeHistoryReader) (tip, maxFee *big.Int, err error) {
+	history, err := backend.FeeHistory(ctx, feeHistoryBlocks, nil, []float64{feeRewardPercentile})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(history.BaseFee) == 0 {
+		return nil, nil, errors.New("node returned no fee history")
+	}
+	// BaseFee includes the block after the newest one
+	baseFee := history.BaseFee[len(history.BaseFee)-1]
+	if baseFee == nil || baseFee.Sign() == 0 {
+		return nil, nil, errors.New("node has no base fee; use -type legacy before London")
+	}
+
+	var tips []*big.Int
+	for _, rewards := range history.Reward {
+		if len(rewards) > 0 && rewards[0] != nil {
+			tips = append(tips, rewards[0])
+		}
+	}
+	tip = new(big.Int).Set(defaultPriorityFee)
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		if median := tips[len(tips)/2]; median.Sign() > 0 {
+			tip.Set(median)
+		}
+	}
+	maxFee = new(big.Int).Mul(baseFee, big.NewInt(2))
+	maxFee.Add(maxFee, tip)
+	return tip, maxFee, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// defaultAccountPath is the BIP-44 path of an Ethereum account's external
+// chain. Address indexes are appended to it.
+const defaultAccountPath = "m/44H/60H/%dH/0"
+
+// derivedAddress is an address derived from a mnemonic at Path
+type derivedAddress struct {
+	Path    string
+	Address string
+}
+
+// parseDerivationPath parses a path such as m/44'/60'/0'/0/0. Hardened
+// components are marked with ', H or h.
+func parseDerivationPath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) < 2 || parts[0] != "m" {
+		return nil, fmt.Errorf("derivation path must start with m/: %q", path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := false
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "H") || strings.HasSuffix(part, "h") {
+			hardened = true
+			part = part[:len(part)-1]
+		}
+		i, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || i >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("invalid derivation path component %q in %q", part, path)
+		}
+		if hardened {
+			i += hdkeychain.HardenedKeyStart
+		}
+		indexes = append(indexes, uint32(i))
+	}
+	return indexes, nil
+}
+
+// accountPath returns the BIP-44 path of an address index of account
+func accountPath(account, index uint32) string {
+	return fmt.Sprintf(defaultAccountPath+"/%d", account, index)
+}
+
+// masterKey returns the BIP-32 master key of the mnemonic and BIP-39
+// passphrase
+func masterKey(mnemonic, passphrase string) (*hdkeychain.ExtendedKey, error) {
+	seed, err := newSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+}
+
+// deriveKey walks the path from key
+func deriveKey(key *hdkeychain.ExtendedKey, path []uint32) (*hdkeychain.ExtendedKey, error) {
+	var err error
+	for _, i := range path {
+		if key, err = key.Derive(i); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// listAddresses returns the first count addresses under basePath, such as
+// m/44H/60H/0H/0
+func listAddresses(mnemonic, passphrase, basePath string, count int) ([]derivedAddress, error) {
+	path, err := parseDerivationPath(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("invalid address count: %d", count)
+	}
+	master, err := masterKey(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	base, err := deriveKey(master, path)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]derivedAddress, count)
+	for i := 0; i < count; i++ {
+		child, err := base.Derive(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := toECDSA(child)
+		if err != nil {
+			return nil, err
+		}
+		addresses[i] = derivedAddress{
+			Path:    fmt.Sprintf("%s/%d", strings.TrimRight(basePath, "/"), i),
+			Address: crypto.PubkeyToAddress(privateKey.PublicKey).Hex(),
+		}
+	}
+	return addresses, nil
+}
+
+func toECDSA(key *hdkeychain.ExtendedKey) (*ecdsa.PrivateKey, error) {
+	btcecPrivKey, err := key.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	return btcecPrivKey.ToECDSA(), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// testMnemonic is the BIP-39 test vector mnemonic of all-zero entropy
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestParseDerivationPath(t *testing.T) {
+	const h = hdkeychain.HardenedKeyStart
+	for _, tt := range []struct {
+		path string
+		want []uint32
+	}{
+		{path: "m/44'/60'/0'/0/0", want: []uint32{h + 44, h + 60, h, 0, 0}},
+		{path: "m/44H/60h/1'/0", want: []uint32{h + 44, h + 60, h + 1, 0}},
+		{path: " m/0/1/2147483647 ", want: []uint32{0, 1, h - 1}},
+		{path: "m/2147483647'", want: []uint32{h + h - 1}},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parseDerivationPath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, path := range []string{
+		"",
+		"m",
+		"m/",
+		"44'/60'/0'/0/0",
+		"M/44'/60'",
+		"m/44'/60'/",
+		"m//0",
+		"m/x",
+		"m/-1",
+		"m/44''",
+		"m/'",
+		"m/2147483648",
+		"m/2147483648'",
+	} {
+		t.Run(fmt.Sprintf("malformed %q", path), func(t *testing.T) {
+			if _, err := parseDerivationPath(path); err == nil {
+				t.Fatal("should be rejected")
+			}
+		})
+	}
+}
+
+func TestAccountPath(t *testing.T) {
+	for _, tt := range []struct {
+		account, index uint32
+		want           string
+	}{
+		{0, 0, "m/44H/60H/0H/0/0"},
+		{2, 5, "m/44H/60H/2H/0/5"},
+	} {
+		got := accountPath(tt.account, tt.index)
+		if got != tt.want {
+			t.Fatalf("account %d index %d: got %s, want %s", tt.account, tt.index, got, tt.want)
+		}
+		if _, err := parseDerivationPath(got); err != nil {
+			t.Fatalf("account path should parse: %v", err)
+		}
+	}
+}
+
+func TestListAddresses(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		passphrase string
+		basePath   string
+		want       []derivedAddress
+	}{
+		{
+			name:     "BIP-44",
+			basePath: "m/44'/60'/0'/0",
+			want: []derivedAddress{
+				{Path: "m/44'/60'/0'/0/0", Address: "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"},
+				{Path: "m/44'/60'/0'/0/1", Address: "0x6Fac4D18c912343BF86fa7049364Dd4E424Ab9C0"},
+			},
+		},
+		{
+			name:     "default account path",
+			basePath: fmt.Sprintf(defaultAccountPath, 0),
+			want: []derivedAddress{
+				{Path: "m/44H/60H/0H/0/0", Address: "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"},
+			},
+		},
+		{
+			name:     "none",
+			basePath: "m/44'/60'/0'/0",
+			want:     []derivedAddress{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listAddresses(testMnemonic, tt.passphrase, tt.basePath, len(tt.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A passphrase derives a different wallet from the same mnemonic
+	got, err := listAddresses(testMnemonic, "TREZOR", "m/44'/60'/0'/0", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Address == "0x9858EfFD232B4033E47d90003D41EC34EcaEda94" {
+		t.Fatal("a passphrase should change the derived address")
+	}
+
+	for _, tt := range []struct {
+		name     string
+		mnemonic string
+		basePath string
+		count    int
+	}{
+		{name: "malformed path", mnemonic: testMnemonic, basePath: "m/44'/60'/x", count: 1},
+		{name: "negative count", mnemonic: testMnemonic, basePath: "m/44'/60'/0'/0", count: -1},
+		{name: "invalid mnemonic", mnemonic: "abandon abandon abandon", basePath: "m/44'/60'/0'/0", count: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := listAddresses(tt.mnemonic, "", tt.basePath, tt.count); err == nil {
+				t.Fatal("should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/term"
+)
+
+// namesFile maps account names to addresses. Key files are named by address,
+// and go-ethereum's keystore ignores dotfiles when scanning the directory.
+const namesFile = ".names.json"
+
+// passphraseEnv is read instead of prompting for a passphrase, for scripts
+const passphraseEnv = "WALLET_PASSPHRASE"
+
+// Keystore is a directory of named accounts, each stored as a Web3 Secret
+// Storage JSON file encrypted with a passphrase.
+type Keystore struct {
+	dir   string
+	ks    *keystore.KeyStore
+	names map[string]common.Address
+}
+
+// OpenKeystore opens the keystore in dir, creating it if needed
+func OpenKeystore(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	k := &Keystore{
+		dir:   dir,
+		ks:    keystore.NewKeyStore(dir, keystore.StandardScryptN, keystore.StandardScryptP),
+		names: make(map[string]common.Address),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, namesFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &k.names); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", namesFile, err)
+		}
+	}
+	return k, nil
+}
+
+// Names returns the account names in the keystore, sorted
+func (k *Keystore) Names() []string {
+	names := make([]string, 0, len(k.names))
+	for name := range k.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Account returns the account stored under name
+func (k *Keystore) Account(name string) (accounts.Account, error) {
+	address, ok := k.names[name]
+	if !ok {
+		return accounts.Account{}, fmt.Errorf("no account named %q", name)
+	}
+	return k.ks.Find(accounts.Account{Address: address})
+}
+
+// Store encrypts key with passphrase and saves it under name
+func (k *Keystore) Store(name string, key *ecdsa.PrivateKey, passphrase string) (accounts.Account, error) {
+	if err := k.checkName(name); err != nil {
+		return accounts.Account{}, err
+	}
+	account, err := k.ks.ImportECDSA(key, passphrase)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	return account, k.setName(name, account.Address)
+}
+
+// Import saves a Web3 Secret Storage key file, encrypted with scrypt or
+// pbkdf2, under name. The key is re-encrypted with newPassphrase.
+func (k *Keystore) Import(name string, keyJSON []byte, passphrase, newPassphrase string) (accounts.Account, error) {
+	if err := k.checkName(name); err != nil {
+		return accounts.Account{}, err
+	}
+	account, err := k.ks.Import(keyJSON, passphrase, newPassphrase)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	return account, k.setName(name, account.Address)
+}
+
+// Export returns the key file of the account named name, re-encrypted with
+// newPassphrase
+func (k *Keystore) Export(name, passphrase, newPassphrase string) ([]byte, error) {
+	account, err := k.Account(name)
+	if err != nil {
+		return nil, err
+	}
+	return k.ks.Export(account, passphrase, newPassphrase)
+}
+
+// Load decrypts the private key of the account named name
+func (k *Keystore) Load(name, passphrase string) (*ecdsa.PrivateKey, error) {
+	account, err := k.Account(name)
+	if err != nil {
+		return nil, err
+	}
+	keyJSON, err := ioutil.ReadFile(account.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return key.PrivateKey, nil
+}
+
+// Delete removes the account named name, after checking its passphrase
+func (k *Keystore) Delete(name, passphrase string) error {
+	account, err := k.Account(name)
+	if err != nil {
+		return err
+	}
+	if err := k.ks.Delete(account, passphrase); err != nil {
+		return err
+	}
+	delete(k.names, name)
+	return k.saveNames()
+}
+
+func (k *Keystore) checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid account name %q", name)
+	}
+	if _, ok := k.names[name]; ok {
+		return fmt.Errorf("account %q already exists", name)
+	}
+	return nil
+}
+
+func (k *Keystore) setName(name string, address common.Address) error {
+	k.names[name] = address
+	return k.saveNames()
+}
+
+func (k *Keystore) saveNames() error {
+	data, err := json.MarshalIndent(k.names, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(k.dir, namesFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(k.dir, namesFile))
+}
+
+// readPassphrase returns $WALLET_PASSPHRASE if set, and otherwise prompts
+// for a keystore passphrase without echoing it
+func readPassphrase(prompt string) (string, error) {
+	return readSecret(passphraseEnv, prompt)
+}
+
+// readNewPassphrase is readPassphrase for a new passphrase
+func readNewPassphrase(prompt string) (string, error) {
+	return readNewSecret(passphraseEnv, prompt)
+}
+
+// readNewSecret is readSecret for a new secret, which is prompted for twice
+// so that a typo can't lock the user out
+func readNewSecret(env, prompt string) (string, error) {
+	if p, ok := os.LookupEnv(env); ok {
+		return p, nil
+	}
+	p, err := readSecret(env, prompt)
+	if err != nil {
+		return "", err
+	}
+	confirm, err := readSecret(env, "Repeat to confirm: ")
+	if err != nil {
+		return "", err
+	}
+	if p != confirm {
+		return "", errors.New("the passphrases don't match")
+	}
+	return p, nil
+}
+
+// readSecret returns the environment variable env if set, and otherwise
+// prompts for a secret without echoing it. The prompt is read from the
+// controlling terminal when stdin is redirected, e.g. to decrypt a file.
+func readSecret(env, prompt string) (string, error) {
+	if p, ok := os.LookupEnv(env); ok {
+		return p, nil
+	}
+	in := os.Stdin
+	if !term.IsTerminal(int(in.Fd())) {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return "", errors.New("no terminal to read a passphrase from; set " + env)
+		}
+		defer tty.Close()
+		in = tty
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(p), err
+}
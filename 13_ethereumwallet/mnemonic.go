@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+	"github.com/tyler-smith/go-bip39/wordlists"
+	"golang.org/x/text/unicode/norm"
+)
+
+// mnemonicPassphraseEnv is read instead of prompting for a BIP-39
+// passphrase, for scripts
+const mnemonicPassphraseEnv = "WALLET_MNEMONIC_PASSPHRASE"
+
+// wordLists are the BIP-39 wordlists by language
+var wordLists = map[string][]string{
+	"english":             wordlists.English,
+	"chinese-simplified":  wordlists.ChineseSimplified,
+	"chinese-traditional": wordlists.ChineseTraditional,
+	"french":              wordlists.French,
+	"italian":             wordlists.Italian,
+	"japanese":            wordlists.Japanese,
+	"korean":              wordlists.Korean,
+	"spanish":             wordlists.Spanish,
+}
+
+// language is the wordlist in use
+var language = "english"
+
+// setLanguage selects the wordlist used to generate and check mnemonics
+func setLanguage(name string) error {
+	list, ok := wordLists[name]
+	if !ok {
+		names := make([]string, 0, len(wordLists))
+		for n := range wordLists {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown mnemonic language %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	bip39.SetWordList(list)
+	language = name
+	return nil
+}
+
+// entropyBits returns the entropy size of a mnemonic of words words
+func entropyBits(words int) (int, error) {
+	switch words {
+	case 12, 15, 18, 21, 24:
+		return words / 3 * 32, nil
+	}
+	return 0, fmt.Errorf("mnemonic has %d words, want 12, 15, 18, 21 or 24", words)
+}
+
+// validateMnemonic checks the length, words and checksum of a mnemonic
+func validateMnemonic(mnemonic string) error {
+	words := strings.Fields(mnemonic)
+	if _, err := entropyBits(len(words)); err != nil {
+		return err
+	}
+	for i, w := range words {
+		if _, ok := bip39.GetWordIndex(w); !ok {
+			msg := fmt.Sprintf("word %d %q is not in the %s wordlist", i+1, w, language)
+			if similar := similarWords(w); len(similar) > 0 {
+				msg += fmt.Sprintf("; did you mean %s?", strings.Join(similar, " or "))
+			}
+			return errors.New(msg)
+		}
+	}
+	if !bip39.IsMnemonicValid(strings.Join(words, " ")) {
+		return errors.New("mnemonic checksum is incorrect; check the words are in the right order")
+	}
+	return nil
+}
+
+// similarWords returns the words in the wordlist sharing w's first four
+// letters, which are unique for each word in the English list
+func similarWords(w string) []string {
+	prefix := []rune(w)
+	if len(prefix) > 4 {
+		prefix = prefix[:4]
+	}
+	if len(prefix) < 3 {
+		return nil
+	}
+	var similar []string
+	for _, word := range bip39.GetWordList() {
+		if strings.HasPrefix(word, string(prefix)) {
+			similar = append(similar, fmt.Sprintf("%q", word))
+		}
+	}
+	if len(similar) > 3 {
+		return nil
+	}
+	return similar
+}
+
+// newSeed validates mnemonic and returns its seed with the BIP-39
+// passphrase, which may be empty
+func newSeed(mnemonic, passphrase string) ([]byte, error) {
+	if err := validateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	return bip39.NewSeed(norm.NFKD.String(mnemonic), norm.NFKD.String(passphrase)), nil
+}
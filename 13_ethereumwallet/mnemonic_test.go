@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/tyler-smith/go-bip39"
+	"github.com/tyler-smith/go-bip39/wordlists"
+)
+
+func TestValidateMnemonic(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		mnemonic string
+		err      string
+	}{
+		{name: "valid", mnemonic: testMnemonic},
+		{name: "extra spaces", mnemonic: "  " + strings.ReplaceAll(testMnemonic, " ", "   ") + "\n"},
+		{name: "bad checksum", mnemonic: strings.Repeat("abandon ", 12), err: "checksum is incorrect"},
+		{name: "swapped words", mnemonic: "about" + strings.Repeat(" abandon", 11), err: "checksum is incorrect"},
+		{name: "too few words", mnemonic: strings.Repeat("abandon ", 10) + "about", err: "has 11 words"},
+		{name: "too many words", mnemonic: strings.Repeat("abandon ", 25), err: "has 25 words"},
+		{name: "empty", mnemonic: "", err: "has 0 words"},
+		{
+			name:     "unknown word",
+			mnemonic: strings.Repeat("abandon ", 5) + "abandn " + strings.Repeat("abandon ", 5) + "about",
+			err:      `word 6 "abandn" is not in the english wordlist; did you mean "abandon"?`,
+		},
+		{
+			name:     "unknown word without suggestions",
+			mnemonic: strings.Repeat("abandon ", 11) + "zzzz",
+			err:      `word 12 "zzzz" is not in the english wordlist`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMnemonic(tt.mnemonic)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("should fail with %q, got %v", tt.err, err)
+			}
+			if strings.HasSuffix(tt.err, "wordlist") && strings.Contains(err.Error(), "did you mean") {
+				t.Fatalf("shouldn't suggest words, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSimilarWords(t *testing.T) {
+	for _, tt := range []struct {
+		word string
+		want []string
+	}{
+		{word: "abandn", want: []string{`"abandon"`}},
+		{word: "abando", want: []string{`"abandon"`}},
+		{word: "abl", want: []string{`"able"`}},
+		{word: "ab", want: nil},
+		{word: "xylo", want: nil},
+	} {
+		got := similarWords(tt.word)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Fatalf("%s: got %v, want %v", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	t.Cleanup(func() {
+		if err := setLanguage("english"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := setLanguage("klingon"); err == nil || !strings.Contains(err.Error(), "want one of") {
+		t.Fatalf("an unknown language should be rejected, got %v", err)
+	}
+	if err := setLanguage("spanish"); err != nil {
+		t.Fatal(err)
+	}
+
+	mnemonic, err := bip39.NewMnemonic(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateMnemonic(mnemonic); err != nil {
+		t.Fatalf("a Spanish mnemonic should be valid, got %v", err)
+	}
+	if strings.Fields(mnemonic)[0] != wordlists.Spanish[0] {
+		t.Fatalf("the mnemonic should use the Spanish wordlist, got %q", mnemonic)
+	}
+	err = validateMnemonic(testMnemonic)
+	if err == nil || !strings.Contains(err.Error(), "not in the spanish wordlist") {
+		t.Fatalf("an English mnemonic should be rejected, got %v", err)
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	// From the BIP-39 test vectors
+	want, _ := hex.DecodeString("c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04")
+	seed, err := newSeed(testMnemonic, "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(seed, want) {
+		t.Fatalf("got seed %x, want %x", seed, want)
+	}
+
+	spaced, err := newSeed(strings.ReplaceAll(testMnemonic, " ", "  "), "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(spaced, want) {
+		t.Fatal("extra spaces between words shouldn't change the seed")
+	}
+
+	// A composed and a decomposed é are the same passphrase
+	composed, err := newSeed(testMnemonic, "caf\u00e9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decomposed, err := newSeed(testMnemonic, "cafe\u0301")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(composed, decomposed) {
+		t.Fatal("passphrases should be NFKD normalized")
+	}
+	if bytes.Equal(composed, want) {
+		t.Fatal("a different passphrase should give a different seed")
+	}
+
+	if _, err := newSeed(strings.Repeat("abandon ", 12), ""); err == nil {
+		t.Fatal("an invalid mnemonic should be rejected")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	// configEnv names the network profiles file instead of the default in
+	// the user's config directory
+	configEnv = "WALLET_CONFIG"
+	// networkEnv selects the network profile when -network isn't given
+	networkEnv = "WALLET_NETWORK"
+	// rpcEnv overrides the profile's RPC URL when -rpc isn't given, e.g.
+	// for a URL containing an API key
+	rpcEnv = "WALLET_RPC_URL"
+
+	defaultNetwork = "sepolia"
+)
+
+// network is a profile of an Ethereum network. NetworkID defaults to
+// ChainID, and Symbol to ETH.
+type network struct {
+	RPC       string `json:"rpc"`
+	ChainID   uint64 `json:"chainId"`
+	NetworkID uint64 `json:"networkId,omitempty"`
+	Explorer  string `json:"explorer,omitempty"`
+	Symbol    string `json:"symbol,omitempty"`
+}
+
+// builtinNetworks are the profiles available without a config file. dev is
+// a local geth --dev node; other dev chains, such as Hardhat's 31337, can be
+// added in the config file.
+var builtinNetworks = map[string]network{
+	"mainnet": {RPC: "https://cloudflare-eth.com", ChainID: 1, Explorer: "https://etherscan.io", Symbol: "ETH"},
+	"sepolia": {RPC: "https://rpc.sepolia.org", ChainID: 11155111, Explorer: "https://sepolia.etherscan.io", Symbol: "ETH"},
+	"dev":     {RPC: "http://127.0.0.1:8545", ChainID: 1337, Symbol: "ETH"},
+}
+
+// loadNetworks returns the builtin profiles, overridden and extended by the
+// JSON object of profiles by name in the config file, if there is one
+func loadNetworks() (map[string]network, error) {
+	networks := make(map[string]network, len(builtinNetworks))
+	for name, n := range builtinNetworks {
+		networks[name] = n
+	}
+
+	path, explicit := os.LookupEnv(configEnv)
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return networks, nil
+		}
+		path = filepath.Join(dir, "ethwallet", "networks.json")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return networks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	configured := make(map[string]network)
+	if err := json.Unmarshal(data, &configured); err != nil {
+		return nil, fmt.Errorf("invalid network config %s: %w", path, err)
+	}
+	for name, n := range configured {
+		if n.RPC == "" || n.ChainID == 0 {
+			return nil, fmt.Errorf("network %q in %s needs rpc and chainId", name, path)
+		}
+		networks[name] = n
+	}
+	return networks, nil
+}
+
+// networkOptions selects a network profile and its node
+type networkOptions struct {
+	name *string
+	rpc  *string
+}
+
+func addNetworkFlags(fs *flag.FlagSet) *networkOptions {
+	return &networkOptions{
+		name: fs.String("network", "", "Network profile; $"+networkEnv+" or "+defaultNetwork+" if empty"),
+		rpc:  fs.String("rpc", "", "Node RPC URL overriding the profile's, e.g. a local dev node; $"+rpcEnv+" if empty"),
+	}
+}
+
+// profile returns the selected network profile, with its RPC URL
+// overridden by -rpc or $WALLET_RPC_URL
+func (o *networkOptions) profile() (*network, error) {
+	name := *o.name
+	if name == "" {
+		name = os.Getenv(networkEnv)
+	}
+	if name == "" {
+		name = defaultNetwork
+	}
+	networks, err := loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+	n, ok := networks[name]
+	if !ok {
+		names := make([]string, 0, len(networks))
+		for name := range networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown network %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	if *o.rpc != "" {
+		n.RPC = *o.rpc
+	} else if rpc := os.Getenv(rpcEnv); rpc != "" {
+		n.RPC = rpc
+	}
+	if n.NetworkID == 0 {
+		n.NetworkID = n.ChainID
+	}
+	if n.Symbol == "" {
+		n.Symbol = "ETH"
+	}
+	return &n, nil
+}
+
+// dial connects to the profile's node and checks it's on the profile's
+// network, so transactions aren't signed for the wrong chain
+func (o *networkOptions) dial() (*ethclient.Client, *network, error) {
+	n, err := o.profile()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := ethclient.Dial(n.RPC)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := n.verify(context.Background(), client); err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return client, n, nil
+}
+
+func (n *network) verify(ctx context.Context, client *ethclient.Client) error {
+	networkID, err := client.NetworkID(ctx)
+	if err != nil {
+		return fmt.Errorf("querying network ID of %s: %w", n.RPC, err)
+	}
+	if !networkID.IsUint64() || networkID.Uint64() != n.NetworkID {
+		return fmt.Errorf("node %s is on network %s, not %d", n.RPC, networkID, n.NetworkID)
+	}
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("querying chain ID of %s: %w", n.RPC, err)
+	}
+	if chainID.Cmp(new(big.Int).SetUint64(n.ChainID)) != 0 {
+		return fmt.Errorf("node %s is on chain %s, not %d", n.RPC, chainID, n.ChainID)
+	}
+	return nil
+}
+
+// txURL returns the explorer page of a transaction, or "" without an
+// explorer
+func (n *network) txURL(hash string) string {
+	if n.Explorer == "" {
+		return ""
+	}
+	return strings.TrimRight(n.Explorer, "/") + "/tx/" + hash
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// droppedAfter is how many checks in a row a transaction must be unknown to
+// the node before it's considered dropped
+const droppedAfter = 5
+
+var (
+	// receiptPollInterval is how often a transaction's status is checked
+	receiptPollInterval = 4 * time.Second
+
+	errTxDropped  = errors.New("transaction was dropped by the node")
+	errTxReplaced = errors.New("transaction was replaced by another with the same nonce")
+)
+
+// receiptBackend is the part of a node used to track transactions
+type receiptBackend interface {
+	TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+}
+
+// txStatus is the progress of a transaction towards confirmation
+type txStatus struct {
+	Pending       bool
+	Receipt       *types.Receipt
+	Confirmations uint64
+}
+
+// txSenderOf returns the account that signed tx
+func txSenderOf(tx *types.Transaction) (common.Address, error) {
+	return types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+}
+
+// waitForTx polls until tx has been mined with confirmations blocks,
+// including its own, reporting each change of status to progress. It
+// returns errTxReplaced if another transaction with its nonce is mined
+// instead, and errTxDropped if the node forgets it.
+func waitForTx(ctx context.Context, backend receiptBackend, tx *types.Transaction, confirmations uint64, progress func(txStatus)) (*types.Receipt, error) {
+	from, err := txSenderOf(tx)
+	if err != nil {
+		return nil, err
+	}
+	var last txStatus
+	missing := 0
+	ticker := time.NewTicker(receiptPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := checkTx(ctx, backend, tx, from)
+		switch {
+		case err == nil:
+			missing = 0
+		case err == ethereum.NotFound:
+			if missing++; missing >= droppedAfter {
+				return nil, errTxDropped
+			}
+		case isTxIndexing(err):
+			// Unknown until the node catches up, which isn't being dropped
+		default:
+			return nil, err
+		}
+		if status != nil {
+			if status.Pending != last.Pending || status.Confirmations != last.Confirmations {
+				progress(*status)
+				last = *status
+			}
+			if status.Receipt != nil && status.Confirmations >= confirmations {
+				return status.Receipt, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkTx returns the status of tx, ethereum.NotFound if the node doesn't
+// know it, or errTxReplaced. While the node is still indexing transactions
+// it may return its indexing error instead of ethereum.NotFound.
+func checkTx(ctx context.Context, backend receiptBackend, tx *types.Transaction, from common.Address) (*txStatus, error) {
+	receipt, err := backend.TransactionReceipt(ctx, tx.Hash())
+	if err == nil {
+		head, err := backend.BlockNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		status := &txStatus{Receipt: receipt}
+		if mined := receipt.BlockNumber.Uint64(); head >= mined {
+			status.Confirmations = head - mined + 1
+		}
+		return status, nil
+	}
+	if err != ethereum.NotFound && !isTxIndexing(err) {
+		return nil, err
+	}
+
+	// Not mined: a mined nonce past tx's means another transaction took it
+	nonce, err := backend.NonceAt(ctx, from, nil)
+	if err != nil {
+		return nil, err
+	}
+	if nonce > tx.Nonce() {
+		// Re-check in case tx itself was mined since, or is mined but not
+		// indexed yet
+		_, err := backend.TransactionReceipt(ctx, tx.Hash())
+		switch {
+		case err == nil:
+			return checkTx(ctx, backend, tx, from)
+		case isTxIndexing(err):
+			return nil, err
+		}
+		return nil, errTxReplaced
+	}
+	if _, _, err := backend.TransactionByHash(ctx, tx.Hash()); err != nil {
+		return nil, err
+	}
+	return &txStatus{Pending: true}, nil
+}
+
+// isTxIndexing reports whether err is a node saying it can't look up
+// transactions yet because it's still indexing them. It's only told apart
+// from other RPC errors by its message.
+func isTxIndexing(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "transaction indexing is in progress")
+}
+
+// waitOptions are the flags of commands that can wait for a transaction
+type waitOptions struct {
+	wait          *bool
+	confirmations *uint64
+	timeout       *time.Duration
+}
+
+func addWaitFlags(fs *flag.FlagSet) *waitOptions {
+	return &waitOptions{
+		wait:          fs.Bool("wait", false, "Wait for the transaction to be confirmed"),
+		confirmations: fs.Uint64("confirmations", 1, "Blocks, including the transaction's own, to wait for"),
+		timeout:       fs.Duration("timeout", 5*time.Minute, "How long to wait for confirmation"),
+	}
+}
+
+// track waits for tx if -wait is set, printing its progress
+func (o *waitOptions) track(backend receiptBackend, tx *types.Transaction) error {
+	if !*o.wait {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *o.timeout)
+	defer cancel()
+	receipt, err := waitForTx(ctx, backend, tx, *o.confirmations, func(s txStatus) {
+		if s.Pending {
+			fmt.Fprintln(os.Stderr, "Pending")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Mined in block %s, %d/%d confirmations\n",
+			s.Receipt.BlockNumber, s.Confirmations, *o.confirmations)
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s not confirmed after %s; check again with status, or use speed-up or cancel", tx.Hash().Hex(), *o.timeout)
+	}
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("transaction reverted in block %s", receipt.BlockNumber)
+	}
+	fmt.Printf("Confirmed in block %s, gas used %d\n", receipt.BlockNumber, receipt.GasUsed)
+	return nil
+}
+
+// bumpFee returns fee raised by 12.5%, above the 10% the node requires to
+// replace a pending transaction
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Rsh(fee, 3)
+	bumped.Add(bumped, fee)
+	return bumped.Add(bumped, big.NewInt(1))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// replacementParams returns a transaction of old's type with old's nonce
+// and higher fees. It repeats old, access list included, to speed it up, or
+// sends nothing to from to cancel it.
+func replacementParams(ctx context.Context, backend txBackend, old *types.Transaction, from common.Address, cancel bool) (*txParams, error) {
+	switch old.Type() {
+	case types.LegacyTxType, types.AccessListTxType, types.DynamicFeeTxType:
+	default:
+		return nil, fmt.Errorf("transactions of type %d can't be replaced", old.Type())
+	}
+	if old.To() == nil && !cancel {
+		return nil, errors.New("contract creations can't be sped up")
+	}
+	p := &txParams{Nonce: old.Nonce(), ChainID: old.ChainId(), GasLimit: old.Gas()}
+	if cancel {
+		p.To, p.Value, p.GasLimit = from, new(big.Int), transferGasLimit
+	} else {
+		p.To, p.Value, p.Data = *old.To(), old.Value(), old.Data()
+		p.AccessList = old.AccessList()
+	}
+	// An empty access list still makes an EIP-2930 transaction
+	if old.Type() == types.AccessListTxType && p.AccessList == nil {
+		p.AccessList = types.AccessList{}
+	}
+
+	if old.Type() == types.DynamicFeeTxType {
+		tip, maxFee, err := estimateFees(ctx, backend)
+		if err != nil {
+			return nil, err
+		}
+		p.Dynamic = true
+		p.GasTipCap = maxBig(bumpFee(old.GasTipCap()), tip)
+		p.GasFeeCap = maxBig(bumpFee(old.GasFeeCap()), maxFee)
+		p.GasFeeCap = maxBig(p.GasFeeCap, p.GasTipCap)
+		return p, nil
+	}
+	gasPrice, err := backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.GasPrice = maxBig(bumpFee(old.GasPrice()), gasPrice)
+	return p, nil
+}
+
+func cmdStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	hash := fs.String("hash", "", "Transaction hash")
+	net := addNetworkFlags(fs)
+	wait := addWaitFlags(fs)
+	fs.Parse(args)
+	if *hash == "" {
+		return errors.New("-hash is required")
+	}
+
+	client, _, err := net.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx := context.Background()
+	tx, _, err := client.TransactionByHash(ctx, common.HexToHash(*hash))
+	if err != nil {
+		return fmt.Errorf("looking up %s: %w", *hash, err)
+	}
+	from, err := txSenderOf(tx)
+	if err != nil {
+		return err
+	}
+	status, err := checkTx(ctx, client, tx, from)
+	if err != nil {
+		return err
+	}
+	if status.Pending {
+		fmt.Println("Pending")
+	} else {
+		fmt.Printf("Mined in block %s, %d confirmations, status %d\n",
+			status.Receipt.BlockNumber, status.Confirmations, status.Receipt.Status)
+	}
+	return wait.track(client, tx)
+}
+
+func cmdSpeedUp(args []string) error {
+	return replaceTx("speed-up", args, false)
+}
+
+func cmdCancel(args []string) error {
+	return replaceTx("cancel", args, true)
+}
+
+// replaceTx signs and sends a replacement for a pending transaction of the
+// selected account
+func replaceTx(name string, args []string, cancel bool) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	keys := addKeyFlags(fs)
+	hash := fs.String("hash", "", "Hash of the pending transaction")
+	net := addNetworkFlags(fs)
+	wait := addWaitFlags(fs)
+	fs.Parse(args)
+	if *hash == "" {
+		return errors.New("-hash is required")
+	}
+
+	client, n, err := net.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx := context.Background()
+	old, pending, err := client.TransactionByHash(ctx, common.HexToHash(*hash))
+	if err != nil {
+		return fmt.Errorf("looking up %s: %w", *hash, err)
+	}
+	if !pending {
+		return fmt.Errorf("%s is already mined", *hash)
+	}
+	privateKey, err := keys.load()
+	if err != nil {
+		return err
+	}
+	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+	if sender, err := txSenderOf(old); err != nil || sender != from {
+		return fmt.Errorf("%s wasn't sent by %s", *hash, *keys.name)
+	}
+
+	p, err := replacementParams(ctx, client, old, from, cancel)
+	if err != nil {
+		return err
+	}
+	signedTx, err := signTx(p, privateKey)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Replacing %s with nonce %d at %s\n", *hash, p.Nonce, describeFees(p))
+	raw, err := encodeTx(signedTx)
+	if err != nil {
+		return err
+	}
+	tx, err := broadcast(client, n, raw)
+	if err != nil {
+		return err
+	}
+	return wait.track(client, tx)
+}
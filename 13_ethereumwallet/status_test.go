@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestWaitForTx(t *testing.T) {
+	pollFast(t)
+	sim, key := newTestChain(t)
+	tx := sendPendingTx(t, sim, key, &txParams{To: testRecipient, Value: big.NewInt(1000)})
+
+	// Mine a block each time the status changes
+	var statuses []txStatus
+	receipt, err := waitForTx(context.Background(), sim, tx, 2, func(s txStatus) {
+		statuses = append(statuses, s)
+		sim.Commit()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.TxHash != tx.Hash() {
+		t.Fatalf("should return the receipt of %s, got %s", tx.Hash(), receipt.TxHash)
+	}
+	if len(statuses) != 3 || !statuses[0].Pending ||
+		statuses[1].Pending || statuses[1].Confirmations != 1 ||
+		statuses[2].Pending || statuses[2].Confirmations != 2 {
+		t.Fatalf("should report pending, then 1 and 2 confirmations, got %+v", statuses)
+	}
+}
+
+func TestWaitForDroppedTx(t *testing.T) {
+	pollFast(t)
+	sim, key := newTestChain(t)
+	sim.Commit() // Until its first block, the node is still indexing
+
+	// Signed but never sent, as if the node had forgotten it
+	p := &txParams{To: testRecipient, Value: big.NewInt(1000)}
+	if err := fillTxParams(context.Background(), sim, crypto.PubkeyToAddress(key.PublicKey), p); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := signTx(p, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = waitForTx(context.Background(), sim, tx, 1, func(s txStatus) {
+		t.Fatalf("an unknown transaction shouldn't report progress, got %+v", s)
+	})
+	if err != errTxDropped {
+		t.Fatalf("should return errTxDropped, got %v", err)
+	}
+}
+
+func TestReplaceTx(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		p      txParams
+		txType uint8
+	}{
+		{name: "legacy", txType: types.LegacyTxType},
+		{
+			name:   "access list",
+			p:      txParams{GasLimit: 30000, AccessList: types.AccessList{{Address: testRecipient, StorageKeys: []common.Hash{{}}}}},
+			txType: types.AccessListTxType,
+		},
+		{name: "dynamic", p: txParams{Dynamic: true}, txType: types.DynamicFeeTxType},
+		{
+			name:   "dynamic with access list",
+			p:      txParams{Dynamic: true, GasLimit: 30000, AccessList: types.AccessList{{Address: testRecipient}}},
+			txType: types.DynamicFeeTxType,
+		},
+	} {
+		for _, cancel := range []bool{false, true} {
+			name := tt.name + " speed-up"
+			if cancel {
+				name = tt.name + " cancel"
+			}
+			t.Run(name, func(t *testing.T) {
+				pollFast(t)
+				sim, key := newTestChain(t)
+				ctx := context.Background()
+				from := crypto.PubkeyToAddress(key.PublicKey)
+				p := tt.p
+				p.To, p.Value = testRecipient, big.NewInt(1000)
+				old := sendPendingTx(t, sim, key, &p)
+				if old.Type() != tt.txType {
+					t.Fatalf("should send a transaction of type %d, got %d", tt.txType, old.Type())
+				}
+
+				rp, err := replacementParams(ctx, sim, old, from, cancel)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rp.Nonce != old.Nonce() {
+					t.Fatalf("the replacement should have nonce %d, got %d", old.Nonce(), rp.Nonce)
+				}
+				if !cancel && (rp.To != *old.To() || rp.Value.Cmp(old.Value()) != 0) {
+					t.Fatal("a speed-up should repeat the transaction")
+				}
+				if cancel && (rp.To != from || rp.Value.Sign() != 0 || rp.GasLimit != transferGasLimit) {
+					t.Fatal("a cancel should send nothing to the sender")
+				}
+
+				// The node refuses a replacement with fees less than 10% higher
+				underpriced := *rp
+				if rp.Dynamic {
+					underpriced.GasTipCap = raiseFee(old.GasTipCap(), 5)
+					underpriced.GasFeeCap = raiseFee(old.GasFeeCap(), 5)
+				} else {
+					underpriced.GasPrice = raiseFee(old.GasPrice(), 5)
+				}
+				signed, err := signTx(&underpriced, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := sim.SendTransaction(ctx, signed); err == nil || !strings.Contains(err.Error(), "underpriced") {
+					t.Fatalf("the node should refuse a replacement with a 5%% fee bump, got %v", err)
+				}
+
+				signed, err = signTx(rp, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if signed.Type() != old.Type() {
+					t.Fatalf("the replacement should be of type %d, got %d", old.Type(), signed.Type())
+				}
+				if !cancel && !reflect.DeepEqual(signed.AccessList(), old.AccessList()) {
+					t.Fatalf("a speed-up should keep the access list %v, got %v", old.AccessList(), signed.AccessList())
+				}
+				if err := sim.SendTransaction(ctx, signed); err != nil {
+					t.Fatalf("the node should accept the replacement: %v", err)
+				}
+				sim.Commit()
+
+				if _, err := checkTx(ctx, sim, old, from); err != errTxReplaced {
+					t.Fatalf("the original should be replaced, got %v", err)
+				}
+				if _, err := waitForTx(ctx, sim, old, 1, func(txStatus) {}); err != errTxReplaced {
+					t.Fatalf("waiting for the original should return errTxReplaced, got %v", err)
+				}
+				status, err := checkTx(ctx, sim, signed, from)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status.Receipt == nil || status.Confirmations != 1 {
+					t.Fatalf("the replacement should be mined, got %+v", status)
+				}
+			})
+		}
+	}
+}
+
+func TestReplaceUnsupportedTx(t *testing.T) {
+	sim, key := newTestChain(t)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	creation := types.NewTx(&types.LegacyTx{Gas: 100000, GasPrice: big.NewInt(1), Data: []byte{0}})
+	if _, err := replacementParams(context.Background(), sim, creation, from, false); err == nil {
+		t.Fatal("a contract creation shouldn't be sped up")
+	}
+	if _, err := replacementParams(context.Background(), sim, creation, from, true); err != nil {
+		t.Fatalf("a contract creation should be cancelled, got %v", err)
+	}
+	blob := types.NewTx(&types.BlobTx{})
+	if _, err := replacementParams(context.Background(), sim, blob, from, true); err == nil {
+		t.Fatal("a blob transaction shouldn't be replaced")
+	}
+}
+
+func TestBumpFee(t *testing.T) {
+	for _, tt := range []struct {
+		fee, want int64
+	}{
+		{0, 1},
+		{1, 2},
+		{7, 8},
+		{8, 10},
+		{100, 113},
+		{1000000000, 1125000001},
+	} {
+		got := bumpFee(big.NewInt(tt.fee))
+		if got.Cmp(big.NewInt(tt.want)) != 0 {
+			t.Fatalf("bumpFee(%d) = %s, want %d", tt.fee, got, tt.want)
+		}
+	}
+
+	// Always strictly above the 10% the node requires
+	for _, fee := range []int64{1, 9, 10, 11, 99, 12345, 30000000000} {
+		min := new(big.Int).Mul(big.NewInt(fee), big.NewInt(110))
+		if bumped := new(big.Int).Mul(bumpFee(big.NewInt(fee)), big.NewInt(100)); bumped.Cmp(min) <= 0 {
+			t.Fatalf("bumpFee(%d) should be more than 10%% higher, got %s", fee, bumpFee(big.NewInt(fee)))
+		}
+	}
+}
+
+func TestRequireHash(t *testing.T) {
+	for name, cmd := range map[string]func([]string) error{
+		"status":   cmdStatus,
+		"speed-up": cmdSpeedUp,
+		"cancel":   cmdCancel,
+	} {
+		if err := cmd(nil); err == nil || !strings.Contains(err.Error(), "-hash") {
+			t.Fatalf("%s should require -hash, got %v", name, err)
+		}
+	}
+}
+
+// testRecipient is the address test transactions send to
+var testRecipient = common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+
+// pollFast makes waitForTx poll without delay for the rest of the test
+func pollFast(t testing.TB) {
+	interval := receiptPollInterval
+	receiptPollInterval = time.Millisecond
+	t.Cleanup(func() { receiptPollInterval = interval })
+}
+
+// raiseFee returns fee raised by percent
+func raiseFee(fee *big.Int, percent int64) *big.Int {
+	raised := new(big.Int).Mul(fee, big.NewInt(100+percent))
+	return raised.Div(raised, big.NewInt(100))
+}
+
+// sendPendingTx fills in p from the chain, signs it with key and sends it
+// without mining it
+func sendPendingTx(t testing.TB, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey, p *txParams) *types.Transaction {
+	t.Helper()
+	ctx := context.Background()
+	if err := fillTxParams(ctx, sim, crypto.PubkeyToAddress(key.PublicKey), p); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := signTx(p, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.SendTransaction(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
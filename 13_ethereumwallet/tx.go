@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// transferGasLimit is the gas used by a plain ETH transfer
+const transferGasLimit = 21000
+
+// txParams describes a transaction to sign. Legacy transactions pay
+// GasPrice, and dynamic-fee (EIP-1559) ones GasTipCap and GasFeeCap. A
+// legacy transaction with an AccessList is signed as an EIP-2930 one.
+type txParams struct {
+	To         common.Address
+	Value      *big.Int
+	Data       []byte
+	Nonce      uint64
+	GasLimit   uint64
+	ChainID    *big.Int
+	AccessList types.AccessList
+
+	Dynamic   bool
+	GasPrice  *big.Int
+	GasTipCap *big.Int
+	GasFeeCap *big.Int
+}
+
+// txBackend is the part of a node used to fill in transaction parameters
+type txBackend interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+	feeHistoryReader
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// txSender sends signed transactions. It's implemented by ethclient.Client
+// and by go-ethereum's simulated backend, for tests.
+type txSender interface {
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// fillTxParams fetches the nonce, fees and chain ID of a transaction from
+// from's account on the node, and estimates its gas limit if unset
+func fillTxParams(ctx context.Context, backend txBackend, from common.Address, p *txParams) error {
+	var err error
+	if p.Nonce, err = backend.PendingNonceAt(ctx, from); err != nil {
+		return err
+	}
+	if p.GasLimit == 0 {
+		to := p.To
+		p.GasLimit, err = backend.EstimateGas(ctx, ethereum.CallMsg{From: from, To: &to, Value: p.Value, Data: p.Data})
+		if err != nil {
+			return fmt.Errorf("estimating gas: %w", err)
+		}
+	}
+	if p.Dynamic {
+		if p.GasTipCap, p.GasFeeCap, err = estimateFees(ctx, backend); err != nil {
+			return err
+		}
+	} else if p.GasPrice, err = backend.SuggestGasPrice(ctx); err != nil {
+		return err
+	}
+	if p.ChainID, err = backend.ChainID(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// signTx builds and signs a transaction
+func signTx(p *txParams, key *ecdsa.PrivateKey) (*types.Transaction, error) {
+	if !p.Dynamic && p.AccessList != nil {
+		tx := types.NewTx(&types.AccessListTx{
+			ChainID:    p.ChainID,
+			Nonce:      p.Nonce,
+			GasPrice:   p.GasPrice,
+			Gas:        p.GasLimit,
+			To:         &p.To,
+			Value:      p.Value,
+			Data:       p.Data,
+			AccessList: p.AccessList,
+		})
+		return types.SignTx(tx, types.NewEIP2930Signer(p.ChainID), key)
+	}
+	if !p.Dynamic {
+		tx := types.NewTransaction(p.Nonce, p.To, p.Value, p.GasLimit, p.GasPrice, p.Data)
+		return types.SignTx(tx, types.NewEIP155Signer(p.ChainID), key)
+	}
+	if p.GasTipCap.Cmp(p.GasFeeCap) > 0 {
+		return nil, fmt.Errorf("priority fee %s gwei is above the max fee %s gwei",
+			formatAmount(p.GasTipCap, gweiDecimals), formatAmount(p.GasFeeCap, gweiDecimals))
+	}
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:    p.ChainID,
+		Nonce:      p.Nonce,
+		GasTipCap:  p.GasTipCap,
+		GasFeeCap:  p.GasFeeCap,
+		Gas:        p.GasLimit,
+		To:         &p.To,
+		Value:      p.Value,
+		Data:       p.Data,
+		AccessList: p.AccessList,
+	})
+	return types.SignTx(tx, types.NewLondonSigner(p.ChainID), key)
+}
+
+// describeFees returns what a transaction pays for gas
+func describeFees(p *txParams) string {
+	if !p.Dynamic {
+		return formatAmount(p.GasPrice, gweiDecimals) + " gwei"
+	}
+	return fmt.Sprintf("max %s gwei, priority %s gwei",
+		formatAmount(p.GasFeeCap, gweiDecimals), formatAmount(p.GasTipCap, gweiDecimals))
+}
+
+// encodeTx returns the raw encoding of a signed transaction: RLP for legacy
+// transactions, and the typed envelope for others
+func encodeTx(tx *types.Transaction) ([]byte, error) {
+	return tx.MarshalBinary()
+}
+
+// decodeTx decodes a raw signed transaction
+func decodeTx(raw []byte) (*types.Transaction, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// sendRawTx decodes a raw signed transaction, possibly signed offline, and
+// sends it
+func sendRawTx(ctx context.Context, sender txSender, raw []byte) (*types.Transaction, error) {
+	tx, err := decodeTx(raw)
+	if err != nil {
+		return nil, err
+	}
+	return tx, sender.SendTransaction(ctx, tx)
+}
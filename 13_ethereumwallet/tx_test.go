@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// testChainGasLimit is the block gas limit of the simulated chain
+const testChainGasLimit = 30000000
+
+func TestEstimateFees(t *testing.T) {
+	sim, _ := newTestChain(t)
+	ctx := context.Background()
+
+	tip, maxFee, err := estimateFees(ctx, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := sim.FeeHistory(ctx, feeHistoryBlocks, nil, []float64{feeRewardPercentile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseFee := history.BaseFee[len(history.BaseFee)-1]
+	if tip.Sign() <= 0 || tip.Cmp(maxFee) > 0 {
+		t.Fatalf("the tip should be positive and at most the max fee, got %s and %s", tip, maxFee)
+	}
+	if min := new(big.Int).Mul(baseFee, big.NewInt(2)); maxFee.Cmp(min) < 0 {
+		t.Fatalf("the max fee should be at least twice the base fee %s, got %s", baseFee, maxFee)
+	}
+}
+
+func TestSignAndSend(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		dynamic bool
+		txType  uint8
+	}{
+		{name: "legacy", txType: types.LegacyTxType},
+		{name: "dynamic", dynamic: true, txType: types.DynamicFeeTxType},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, key := newTestChain(t)
+			to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+			p := &txParams{To: to, Value: big.NewInt(1000), Dynamic: tt.dynamic}
+			receipt := sendTestTx(t, sim, key, p)
+			if receipt.Type != tt.txType {
+				t.Fatalf("the receipt should be of type %d, got %d", tt.txType, receipt.Type)
+			}
+			balance, err := sim.BalanceAt(context.Background(), to, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Cmp(p.Value) != 0 {
+				t.Fatalf("the recipient should have %s wei, got %s", p.Value, balance)
+			}
+		})
+	}
+}
+
+func TestOfflineSign(t *testing.T) {
+	sim, key := newTestChain(t)
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	o := addTxFlags(fs)
+	if err := fs.Parse([]string{"-offline", "-nonce", "0", "-max-fee", "10", "-priority-fee", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign without a node, as on an air-gapped machine...
+	to := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	p := &txParams{To: to, Value: big.NewInt(1000), Dynamic: true}
+	if err := o.offlineParams(p, &network{ChainID: 1337}); err != nil {
+		t.Fatal(err)
+	}
+	// ...and broadcast the raw transaction from a connected one
+	receipt := sendSignedTx(t, sim, key, p)
+
+	tx, _, err := sim.TransactionByHash(context.Background(), receipt.TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Nonce() != 0 || tx.ChainId().Uint64() != 1337 || *tx.To() != to {
+		t.Fatalf("should send nonce 0 on chain 1337 to %s, got nonce %d on chain %s to %s",
+			to.Hex(), tx.Nonce(), tx.ChainId(), tx.To().Hex())
+	}
+	if p.GasLimit != transferGasLimit {
+		t.Fatalf("an offline transfer should use gas limit %d, got %d", transferGasLimit, p.GasLimit)
+	}
+}
+
+// newTestChain returns a simulated chain with an account funded with 100 ETH,
+// and the account's key. The chain is closed after the test.
+func newTestChain(t testing.TB) (*backends.SimulatedBackend, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	funds, _ := parseAmount("100", etherDecimals)
+	alloc := types.GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): {Balance: funds}}
+	sim := backends.NewSimulatedBackend(alloc, testChainGasLimit)
+	t.Cleanup(func() { sim.Close() })
+	return sim, key
+}
+
+// sendTestTx fills in p from the chain, signs it with key, sends it raw and
+// mines it, and returns its receipt after checking that it succeeded.
+func sendTestTx(t testing.TB, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey, p *txParams) *types.Receipt {
+	t.Helper()
+	ctx := context.Background()
+	if err := fillTxParams(ctx, sim, crypto.PubkeyToAddress(key.PublicKey), p); err != nil {
+		t.Fatal(err)
+	}
+	return sendSignedTx(t, sim, key, p)
+}
+
+// sendSignedTx signs p with key, sends it raw and mines it, and returns its
+// receipt after checking that it succeeded.
+func sendSignedTx(t testing.TB, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey, p *txParams) *types.Receipt {
+	t.Helper()
+	ctx := context.Background()
+	signed, err := signTx(p, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := encodeTx(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := sendRawTx(ctx, sim, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Commit()
+	receipt, err := sim.TransactionReceipt(ctx, tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("transaction %s failed", tx.Hash().Hex())
+	}
+	return receipt
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// etherDecimals is the number of decimal places of ETH in wei
+	etherDecimals = 18
+	// gweiDecimals is the number of decimal places of gwei in wei
+	gweiDecimals = 9
+)
+
+// parseAmount converts a decimal amount such as "0.1" to base units of a
+// currency with the given decimals, e.g. wei for ETH
+func parseAmount(s string, decimals int) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole+frac == "" || strings.Trim(whole+frac, "0123456789") != "" {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(frac) > decimals {
+		return nil, fmt.Errorf("amount %q has more than %d decimal places", s, decimals)
+	}
+	n, _ := new(big.Int).SetString(whole+frac+strings.Repeat("0", decimals-len(frac)), 10)
+	return n, nil
+}
+
+// formatAmount formats base units of a currency with the given decimals as
+// a decimal amount, without trailing zeros
+func formatAmount(n *big.Int, decimals int) string {
+	digits := new(big.Int).Abs(n).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if n.Sign() < 0 {
+		whole = "-" + whole
+	}
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
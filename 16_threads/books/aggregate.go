@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// aggregate summarises a numeric field over a group of instances.
+// Group is empty when the instances weren't grouped.
+type aggregate struct {
+	Group string  `json:"group,omitempty"`
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	Avg   float64 `json:"avg"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// aggregateDocs summarises field over docs, grouped by the value at the
+// groupBy path, or as a single group if groupBy is empty. Groups are sorted.
+// Docs missing field are skipped.
+func aggregateDocs(docs []map[string]interface{}, field, groupBy string) ([]aggregate, error) {
+	groups := make(map[string]*aggregate)
+	for _, doc := range docs {
+		v, ok := fieldValue(doc, field)
+		if !ok {
+			continue
+		}
+		n, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a number: %v", field, v)
+		}
+
+		var key string
+		if groupBy != "" {
+			g, ok := fieldValue(doc, groupBy)
+			if !ok {
+				continue
+			}
+			key = fmt.Sprint(g)
+		}
+		a, ok := groups[key]
+		if !ok {
+			a = &aggregate{Group: key, Min: n, Max: n}
+			groups[key] = a
+		}
+		a.Count++
+		a.Sum += n
+		if n < a.Min {
+			a.Min = n
+		}
+		if n > a.Max {
+			a.Max = n
+		}
+	}
+
+	res := make([]aggregate, 0, len(groups))
+	for _, a := range groups {
+		a.Avg = a.Sum / float64(a.Count)
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Group < res[j].Group })
+	return res, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+)
+
+// sortFields are the book fields the list endpoint can order by.
+var sortFields = map[string]bool{
+	"Title":           true,
+	"Author":          true,
+	"Meta.TotalReads": true,
+	"Meta.Rating":     true,
+}
+
+type apiError struct {
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+type bookList struct {
+	Total  int                      `json:"total"`
+	Offset int                      `json:"offset"`
+	Books  []map[string]interface{} `json:"books"`
+}
+
+// bookAPI serves CRUD endpoints for the Book collection.
+// Writes go through merger, if set, so that they carry revisions.
+type bookAPI struct {
+	d      *db.DB
+	books  *repository[book]
+	merger *merger
+}
+
+func newBookAPI(d *db.DB, books *repository[book], m *merger) http.Handler {
+	a := &bookAPI{d: d, books: books, merger: m}
+	router := mux.NewRouter()
+	router.HandleFunc("/books", a.list).Methods("GET")
+	router.HandleFunc("/books", a.create).Methods("POST")
+	router.HandleFunc("/books/events", a.events).Methods("GET")
+	router.HandleFunc("/books/explain", a.explain).Methods("GET")
+	router.HandleFunc("/books/stats", a.stats).Methods("GET")
+	router.HandleFunc("/books/{id}", a.get).Methods("GET")
+	router.HandleFunc("/books/{id}", a.update).Methods("PUT")
+	router.HandleFunc("/books/{id}", a.delete).Methods("DELETE")
+	router.HandleFunc("/books/{id}/conflicts", a.conflicts).Methods("GET")
+	return router
+}
+
+// list handles GET /books with the listQuery filters, plus
+// authors=a,b&titlePrefix=&titleContains=&fields=Title,Meta.Rating&offset=&limit=
+func (a *bookAPI) list(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	f, offset, err := listFind(params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	docs, total, err := a.books.QueryDocs(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, bookList{Total: total, Offset: offset, Books: docs})
+}
+
+// explain handles GET /books/explain with the list filters, returning the
+// query plan instead of the books.
+func (a *bookAPI) explain(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.books.Explain(q))
+}
+
+// stats handles GET /books/stats?field=Meta.TotalReads&groupBy=Author with the
+// list filters, returning aggregates of field over the matching books.
+func (a *bookAPI) stats(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	field := params.Get("field")
+	if field == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing field"))
+		return
+	}
+	f, _, err := listFind(params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if params.Get("limit") == "" {
+		f.Limit(0) // Aggregate every match by default
+	}
+	res, err := a.books.Aggregate(f, field, params.Get("groupBy"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (a *bookAPI) create(w http.ResponseWriter, r *http.Request) {
+	b := &book{}
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	b.ID = "" // Always generated
+	var err error
+	if a.merger != nil {
+		err = a.merger.Create(b)
+	} else {
+		_, err = a.books.Create(b)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, b)
+}
+
+func (a *bookAPI) get(w http.ResponseWriter, r *http.Request) {
+	b, err := a.books.Get(core.InstanceID(mux.Vars(r)["id"]))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (a *bookAPI) update(w http.ResponseWriter, r *http.Request) {
+	id := core.InstanceID(mux.Vars(r)["id"])
+	if _, err := a.books.Get(id); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	b := &book{}
+	if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	b.ID = id
+	var err error
+	if a.merger != nil {
+		err = a.merger.Save(b)
+	} else {
+		err = a.books.Save(b)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (a *bookAPI) delete(w http.ResponseWriter, r *http.Request) {
+	id := core.InstanceID(mux.Vars(r)["id"])
+	if _, err := a.books.Get(id); err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	var err error
+	if a.merger != nil {
+		err = a.merger.Delete(id)
+	} else {
+		err = a.books.Delete(id)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// conflicts handles GET /books/{id}/conflicts, listing the concurrent edits
+// of the book that were merged.
+func (a *bookAPI) conflicts(w http.ResponseWriter, r *http.Request) {
+	if a.merger == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("conflict resolution is disabled"))
+		return
+	}
+	res, err := a.merger.Conflicts(core.InstanceID(mux.Vars(r)["id"]))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// events handles GET /books/events?author=&title=&minRating=&maxRating= as a
+// server-sent event stream of bookEvents.
+func (a *bookAPI) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+	filter, err := bookFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := watchBooks(r.Context(), a.d, a.books, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		flusher.Flush()
+	}
+}
+
+// listFind adds the IN, text match, pagination and projection parameters to
+// listQuery. It also returns the offset.
+func listFind(params url.Values) (*findQuery, int, error) {
+	q, err := listQuery(params)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset, err := intParam(params, "offset", 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	limit, err := intParam(params, "limit", 50)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f := newFind(q).Skip(offset).Limit(limit)
+	if authors := params.Get("authors"); authors != "" {
+		var values []interface{}
+		for _, a := range strings.Split(authors, ",") {
+			values = append(values, a)
+		}
+		f.In("Author", values...)
+	}
+	if prefix := params.Get("titlePrefix"); prefix != "" {
+		f.Prefix("Title", prefix)
+	}
+	if substr := params.Get("titleContains"); substr != "" {
+		f.Contains("Title", substr)
+	}
+	if fields := params.Get("fields"); fields != "" {
+		f.Select(strings.Split(fields, ",")...)
+	}
+	return f, offset, nil
+}
+
+// listQuery maps the list filters and sort order onto a db.Query.
+func listQuery(params url.Values) (*db.Query, error) {
+	var q *db.Query
+	where := func(field string) *db.Criterion {
+		if q == nil {
+			return db.Where(field)
+		}
+		return q.And(field)
+	}
+
+	if author := params.Get("author"); author != "" {
+		q = where("Author").Eq(author)
+	}
+	if title := params.Get("title"); title != "" {
+		q = where("Title").Eq(title)
+	}
+	if s := params.Get("minRating"); s != "" {
+		rating, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minRating: %s", s)
+		}
+		q = where("Meta.Rating").Ge(rating)
+	}
+	if s := params.Get("maxRating"); s != "" {
+		rating, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxRating: %s", s)
+		}
+		q = where("Meta.Rating").Le(rating)
+	}
+	if q == nil {
+		q = &db.Query{}
+	}
+
+	if field := params.Get("sort"); field != "" {
+		if !sortFields[field] {
+			return nil, fmt.Errorf("cannot sort by %s", field)
+		}
+		switch params.Get("order") {
+		case "", "asc":
+			q = q.OrderBy(field)
+		case "desc":
+			q = q.OrderByDesc(field)
+		default:
+			return nil, fmt.Errorf("invalid order: %s", params.Get("order"))
+		}
+	}
+	return q, nil
+}
+
+func intParam(params url.Values, name string, def int) (int, error) {
+	s := params.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", name, s)
+	}
+	return v, nil
+}
+
+func statusFor(err error) int {
+	if err == db.ErrInstanceNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as an apiError. Validation errors are always sent as
+// 422 with the failing fields.
+func writeError(w http.ResponseWriter, status int, err error) {
+	e := apiError{Message: err.Error()}
+	var verr *validationError
+	if errors.As(err, &verr) {
+		status = http.StatusUnprocessableEntity
+		e.Fields = verr.Fields
+	}
+	writeJSON(w, status, e)
+}
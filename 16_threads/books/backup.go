@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alecthomas/jsonschema"
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+)
+
+// importBatch is how many instances are written per CreateMany/SaveMany.
+const importBatch = 500
+
+// backupHeader is the first line of a backup. Each following line is one
+// instance, including its _id.
+type backupHeader struct {
+	Collection string             `json:"collection"`
+	Version    int                `json:"version"`
+	Schema     *jsonschema.Schema `json:"schema"`
+}
+
+// rejectedRecord is a backup line that failed to import.
+type rejectedRecord struct {
+	Line   int             `json:"line"`
+	ID     core.InstanceID `json:"_id,omitempty"`
+	Errors []string        `json:"errors"`
+}
+
+type importReport struct {
+	Created  int              `json:"created"`
+	Updated  int              `json:"updated"`
+	Rejected []rejectedRecord `json:"rejected"`
+}
+
+// exportCollection writes every instance of c to w as JSON Lines, after a
+// header recording the collection's schema version.
+func exportCollection(w io.Writer, c *db.Collection, m *migrator) error {
+	bw := bufio.NewWriter(w)
+	header, err := json.Marshal(backupHeader{
+		Collection: c.GetName(),
+		Version:    m.latest(),
+		Schema:     m.config(m.latest()).Schema,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = bw.Write(append(header, '\n')); err != nil {
+		return err
+	}
+
+	res, err := c.Find(&db.Query{})
+	if err != nil {
+		return err
+	}
+	for _, item := range res {
+		var line bytes.Buffer
+		if err := json.Compact(&line, item); err != nil {
+			return err
+		}
+		line.WriteByte('\n')
+		if _, err := bw.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// importCollection upserts the instances in a backup read from r into c.
+// Instances from a backup at an older schema version are migrated first.
+// Instances that fail to migrate or fail validators, which should include
+// the schema check, are skipped and reported.
+func importCollection(r io.Reader, c *db.Collection, m *migrator, validators []validator) (*importReport, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("missing backup header")
+	}
+	header := &backupHeader{}
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil {
+		return nil, fmt.Errorf("invalid backup header: %w", err)
+	}
+	if header.Collection != c.GetName() {
+		return nil, fmt.Errorf("backup is of %s, not %s", header.Collection, c.GetName())
+	}
+	if header.Version < 1 || header.Version > m.latest() {
+		return nil, fmt.Errorf("backup is at version %d, not 1 to %d", header.Version, m.latest())
+	}
+
+	report := &importReport{}
+	var creates, saves [][]byte
+	pending := make(map[core.InstanceID]bool)
+	flush := func() error {
+		if len(creates) > 0 {
+			if _, err := c.CreateMany(creates); err != nil {
+				return err
+			}
+		}
+		if len(saves) > 0 {
+			if err := c.SaveMany(saves); err != nil {
+				return err
+			}
+		}
+		report.Created += len(creates)
+		report.Updated += len(saves)
+		creates, saves = nil, nil
+		pending = make(map[core.InstanceID]bool)
+		return nil
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		doc := append([]byte(nil), scanner.Bytes()...)
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		if !json.Valid(doc) {
+			report.Rejected = append(report.Rejected, rejectedRecord{Line: line, Errors: []string{"invalid JSON"}})
+			continue
+		}
+		id := docID(doc)
+		var err error
+		if header.Version < m.latest() {
+			if doc, err = migrateDoc(doc, m, header.Version); err != nil {
+				report.Rejected = append(report.Rejected, rejectedRecord{Line: line, ID: id, Errors: []string{err.Error()}})
+				continue
+			}
+		}
+		if err = validate(doc, validators); err != nil {
+			var verr *validationError
+			if !errors.As(err, &verr) {
+				return nil, err
+			}
+			rej := rejectedRecord{Line: line, ID: id}
+			for _, f := range verr.Fields {
+				rej.Errors = append(rej.Errors, f.String())
+			}
+			report.Rejected = append(report.Rejected, rej)
+			continue
+		}
+
+		if pending[id] {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		exists := false
+		if id != "" {
+			if exists, err = c.Has(id); err != nil {
+				return nil, err
+			}
+			pending[id] = true
+		}
+		if exists {
+			saves = append(saves, doc)
+		} else {
+			creates = append(creates, doc)
+		}
+		if len(creates)+len(saves) >= importBatch {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// migrateDoc applies m's transforms to a JSON instance at version from.
+func migrateDoc(doc []byte, m *migrator, from int) ([]byte, error) {
+	v := make(map[string]interface{})
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	if err := m.transform(v, from); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	_, books := newTestBooks(t)
+	seedBooks(t, books)
+
+	var buf bytes.Buffer
+	m := bookMigrator(nil)
+	if err := exportCollection(&buf, books.Collection(), m); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString(`{"Title":"NoAuthor","Meta":{"TotalReads":"many","Rating":1}}` + "\n")
+	buf.WriteString(`{"_id":"overrated","Title":"Overrated","Author":"Author3","Meta":{"TotalReads":1,"Rating":9}}` + "\n")
+	report, err := importCollection(&buf, books.Collection(), m, bookValidators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 0 || report.Updated != 3 || len(report.Rejected) != 2 {
+		t.Fatalf("import should update three books and reject two, got %+v", report)
+	}
+	rej := report.Rejected[1]
+	if rej.Line != 6 || len(rej.Errors) != 1 || !strings.HasPrefix(rej.Errors[0], "Meta.Rating:") {
+		t.Fatalf("the rating of 9 should be rejected on line 6, got %+v", rej)
+	}
+}
+
+func TestImportOlderVersion(t *testing.T) {
+	_, books := newTestBooks(t)
+	m := bookMigrator(nil)
+
+	// A version 1 backup predates Meta.Tags, which the version 2 transform adds
+	backup := `{"collection":"Book","version":1}` + "\n" +
+		`{"_id":"old","Title":"Old","Author":"Author3","Meta":{"TotalReads":1,"Rating":2}}` + "\n" +
+		`{"_id":"nometa","Title":"NoMeta","Author":"Author3"}` + "\n"
+	report, err := importCollection(strings.NewReader(backup), books.Collection(), m, bookValidators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || len(report.Rejected) != 1 || report.Rejected[0].ID != "nometa" {
+		t.Fatalf("import should create one book and reject the one without Meta, got %+v", report)
+	}
+	raw, err := books.Collection().FindByID("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), `"Tags":[]`) {
+		t.Fatalf("the imported book should be migrated to have Tags, got %s", raw)
+	}
+
+	future := fmt.Sprintf(`{"collection":"Book","version":%d}`+"\n", m.latest()+1)
+	if _, err := importCollection(strings.NewReader(future), books.Collection(), m, bookValidators()); err == nil {
+		t.Fatal("a backup newer than the schema should be refused")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/textileio/go-threads/db"
+	"github.com/textileio/go-threads/util"
+)
+
+const (
+	// benchBooks is how many books the benchmarks query
+	benchBooks = 100000
+	benchBatch = 1000
+)
+
+var (
+	benchOnce sync.Once
+	// benchRepos holds the same books in an indexed and an unindexed
+	// collection. Seeding takes a while, so it's shared by every benchmark.
+	benchRepos struct {
+		indexed, unindexed *repository[book]
+		clean              func()
+		err                error
+	}
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchRepos.clean != nil {
+		benchRepos.clean()
+	}
+	os.Exit(code)
+}
+
+func BenchmarkFindAuthorIndexed(b *testing.B) {
+	benchFind(b, benchRepo(b, true), func() *db.Query { return db.Where("Author").Eq("Author7") })
+}
+
+func BenchmarkFindAuthorUnindexed(b *testing.B) {
+	benchFind(b, benchRepo(b, false), func() *db.Query { return db.Where("Author").Eq("Author7") })
+}
+
+func BenchmarkRatingRangeIndexed(b *testing.B) {
+	benchFind(b, benchRepo(b, true), func() *db.Query { return db.Where("Meta.Rating").Ge(4.8) })
+}
+
+func BenchmarkRatingRangeUnindexed(b *testing.B) {
+	benchFind(b, benchRepo(b, false), func() *db.Query { return db.Where("Meta.Rating").Ge(4.8) })
+}
+
+func benchFind(b *testing.B, books *repository[book], query func() *db.Query) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := books.Find(query()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchRepo returns the indexed or unindexed collection of benchBooks books,
+// seeding both the first time.
+func benchRepo(b *testing.B, indexed bool) *repository[book] {
+	benchOnce.Do(func() {
+		d, clean := createMemDB()
+		benchRepos.clean = clean
+		c, err := bookMigrator(d).upgrade()
+		if err != nil {
+			benchRepos.err = err
+			return
+		}
+		plain, err := d.NewCollection(db.CollectionConfig{
+			Name:   "BookNoIndex",
+			Schema: util.SchemaFromInstance(&book{}, false),
+		})
+		if err != nil {
+			benchRepos.err = err
+			return
+		}
+		benchRepos.indexed = newRepository[book](c)
+		benchRepos.unindexed = newRepository[book](plain)
+		for _, r := range []*repository[book]{benchRepos.indexed, benchRepos.unindexed} {
+			if benchRepos.err = seedBenchBooks(r); benchRepos.err != nil {
+				return
+			}
+		}
+	})
+	if benchRepos.err != nil {
+		b.Fatal(benchRepos.err)
+	}
+	if indexed {
+		return benchRepos.indexed
+	}
+	return benchRepos.unindexed
+}
+
+// seedBenchBooks creates benchBooks books spread over 1000 authors and
+// ratings from 0 to 4.9.
+func seedBenchBooks(books *repository[book]) error {
+	for i := 0; i < benchBooks; i += benchBatch {
+		batch := make([]*book, 0, benchBatch)
+		for j := i; j < i+benchBatch && j < benchBooks; j++ {
+			batch = append(batch, &book{
+				Title:  fmt.Sprintf("Title%d", j),
+				Author: fmt.Sprintf("Author%d", j%1000),
+				Meta:   bookStats{TotalReads: j, Rating: float64(j%50) / 10},
+			})
+		}
+		if _, err := books.CreateMany(batch...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
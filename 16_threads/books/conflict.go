@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+	"github.com/textileio/go-threads/util"
+)
+
+const (
+	// conflictCollection logs the concurrent book edits that were merged.
+	conflictCollection = "Conflict"
+	// maxAncestors is how many revisions before its parent a revision lists.
+	maxAncestors = 16
+)
+
+// revision identifies a version of a book. Two versions are concurrent,
+// e.g. edited by peers that were offline, if neither is an ancestor of the
+// other. Ancestors lists the revisions before Parent, nearest first, so
+// that a peer which missed some of them still recognises a descendant.
+type revision struct {
+	ID        string
+	Parent    string   `json:",omitempty"`
+	Ancestors []string `json:",omitempty"`
+	Time      int64
+	Peer      string
+}
+
+// lineage returns the parent and known ancestors of r, nearest first.
+func (r *revision) lineage() []string {
+	if r == nil || r.Parent == "" {
+		return nil
+	}
+	return append([]string{r.Parent}, r.Ancestors...)
+}
+
+// conflict records both versions of a concurrent edit and how it was merged.
+type conflict struct {
+	ID       core.InstanceID `json:"_id"`
+	BookID   core.InstanceID
+	Local    string
+	Remote   string
+	Resolved string
+	Strategy string
+	Time     int64
+}
+
+// mergeStrategy resolves concurrent versions of a book. base is their common
+// ancestor, or nil if this peer never saw it.
+type mergeStrategy struct {
+	Name    string
+	Resolve func(base, local, remote *book) *book
+}
+
+var (
+	// lastWriterWins keeps the version saved last by wall clock, breaking
+	// ties by peer.
+	lastWriterWins = mergeStrategy{Name: "lww", Resolve: func(_, local, remote *book) *book {
+		if local.Rev.Time > remote.Rev.Time ||
+			(local.Rev.Time == remote.Rev.Time && local.Rev.Peer > remote.Rev.Peer) {
+			return local
+		}
+		return remote
+	}}
+
+	// fieldMerge keeps the fields each side changed from base, preferring
+	// the last writer's value for fields both changed. Without a base it
+	// falls back to lastWriterWins.
+	fieldMerge = mergeStrategy{Name: "fields", Resolve: func(base, local, remote *book) *book {
+		latest, other := lastWriterWins.Resolve(base, local, remote), local
+		if latest == local {
+			other = remote
+		}
+		if base == nil {
+			return latest
+		}
+		merged := mergeDocs(toDoc(base), toDoc(other), toDoc(latest))
+		b := &book{}
+		util.InstanceFromJSON(util.JSONFromInstance(merged), b)
+		return b
+	}}
+)
+
+// mergeStrategies are the strategies -merge selects by name.
+var mergeStrategies = map[string]mergeStrategy{
+	lastWriterWins.Name: lastWriterWins,
+	fieldMerge.Name:     fieldMerge,
+}
+
+// registerStrategy makes a resolver callback selectable by name, e.g. from
+// an init func, and returns it as a strategy.
+func registerStrategy(name string, resolve func(base, local, remote *book) *book) mergeStrategy {
+	s := mergeStrategy{Name: name, Resolve: resolve}
+	mergeStrategies[name] = s
+	return s
+}
+
+// strategyNames returns the names of the registered strategies, sorted.
+func strategyNames() []string {
+	names := make([]string, 0, len(mergeStrategies))
+	for name := range mergeStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// merger stamps this peer's book writes with revisions and resolves
+// replicated edits that were made concurrently with them.
+type merger struct {
+	books     *repository[book]
+	conflicts *db.Collection
+	strategy  mergeStrategy
+	peer      string
+	listener  db.Listener
+
+	lk      sync.Mutex
+	seen    map[core.InstanceID]*book    // Latest version of each book on this peer
+	history map[string]*book             // Versions by revision ID, for merge bases
+	kept    map[core.InstanceID][]string // Revision IDs in history for each book
+}
+
+// newMerger returns a merger that starts from the books stored in d, so that
+// edits made before a restart are still merged with concurrent ones that
+// replicate after it. Merge bases older than the stored versions are gone,
+// so fieldMerge falls back to lastWriterWins for those. It listens for saves
+// before reading the stored books, so that run handles any made in between.
+func newMerger(d *db.DB, books *repository[book], strategy mergeStrategy) (*merger, error) {
+	conflicts := d.GetCollection(conflictCollection)
+	if conflicts == nil {
+		var err error
+		conflicts, err = d.NewCollection(conflictsConfig())
+		if err != nil {
+			return nil, err
+		}
+	}
+	name := books.Collection().GetName()
+	l, err := d.Listen(
+		db.ListenOption{Type: db.ListenSave, Collection: name},
+		db.ListenOption{Type: db.ListenDelete, Collection: name})
+	if err != nil {
+		return nil, err
+	}
+	m := &merger{
+		books:     books,
+		conflicts: conflicts,
+		strategy:  strategy,
+		peer:      string(core.NewInstanceID()),
+		listener:  l,
+		seen:      make(map[core.InstanceID]*book),
+		history:   make(map[string]*book),
+		kept:      make(map[core.InstanceID][]string),
+	}
+	stored, err := books.Find(&db.Query{})
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	for _, b := range stored {
+		m.observe(b)
+	}
+	return m, nil
+}
+
+func conflictsConfig() db.CollectionConfig {
+	return db.CollectionConfig{
+		Name:   conflictCollection,
+		Schema: util.SchemaFromInstance(&conflict{}, false),
+	}
+}
+
+// Create adds b with its first revision.
+func (m *merger) Create(b *book) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	b.Rev = m.newRevision(nil)
+	if _, err := m.books.Create(b); err != nil {
+		return err
+	}
+	m.observe(b)
+	return nil
+}
+
+// Save stores b as a new revision of the version this peer last saw.
+func (m *merger) Save(b *book) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	parent := b.Rev
+	if prev, ok := m.seen[b.ID]; ok && prev.Rev != nil {
+		parent = prev.Rev
+	}
+	b.Rev = m.newRevision(parent)
+	if err := m.books.Save(b); err != nil {
+		return err
+	}
+	m.observe(b)
+	return nil
+}
+
+// Delete removes the book with id and what this peer kept of it.
+func (m *merger) Delete(id core.InstanceID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if err := m.books.Delete(id); err != nil {
+		return err
+	}
+	m.forget(id)
+	return nil
+}
+
+// Conflicts returns the logged conflicts for a book.
+func (m *merger) Conflicts(id core.InstanceID) ([]*conflict, error) {
+	res, err := m.conflicts.Find(db.Where("BookID").Eq(string(id)))
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[conflict](res), nil
+}
+
+// run resolves conflicting saves until ctx is done.
+func (m *merger) run(ctx context.Context) error {
+	defer m.listener.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case a, ok := <-m.listener.Channel():
+			if !ok {
+				return nil
+			}
+			if a.Type == db.ActionDelete {
+				m.lk.Lock()
+				m.forget(a.ID)
+				m.lk.Unlock()
+				continue
+			}
+			if err := m.handleSave(a.ID); err != nil {
+				log.Printf("error merging book %s: %v\n", a.ID, err)
+			}
+		}
+	}
+}
+
+func (m *merger) handleSave(id core.InstanceID) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	remote, err := m.books.Get(id)
+	if err == db.ErrInstanceNotFound {
+		m.forget(id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	local, ok := m.seen[id]
+	if !ok || local.Rev == nil || remote.Rev == nil ||
+		remote.Rev.ID == local.Rev.ID || m.isAncestor(local.Rev.ID, remote.Rev) ||
+		sameContent(local, remote) {
+		m.observe(remote) // Not concurrent with what we had
+		return nil
+	}
+
+	resolved := m.strategy.Resolve(m.mergeBase(local.Rev, remote.Rev), local, remote)
+	if err := m.logConflict(id, local, remote, resolved); err != nil {
+		return err
+	}
+	m.observe(remote)
+	if sameContent(resolved, remote) {
+		return nil
+	}
+	merged := *resolved
+	merged.ID = id
+	merged.Rev = m.newRevision(remote.Rev)
+	if err := m.books.Save(&merged); err != nil {
+		return err
+	}
+	m.observe(&merged)
+	return nil
+}
+
+func (m *merger) logConflict(id core.InstanceID, local, remote, resolved *book) error {
+	_, err := m.conflicts.Create(util.JSONFromInstance(&conflict{
+		BookID:   id,
+		Local:    string(util.JSONFromInstance(local)),
+		Remote:   string(util.JSONFromInstance(remote)),
+		Resolved: string(util.JSONFromInstance(resolved)),
+		Strategy: m.strategy.Name,
+		Time:     time.Now().UnixNano(),
+	}))
+	return err
+}
+
+func (m *merger) newRevision(parent *revision) *revision {
+	r := &revision{
+		ID:   string(core.NewInstanceID()),
+		Time: time.Now().UnixNano(),
+		Peer: m.peer,
+	}
+	if parent != nil {
+		r.Parent = parent.ID
+		r.Ancestors = parent.lineage()
+		if len(r.Ancestors) > maxAncestors {
+			r.Ancestors = r.Ancestors[:maxAncestors]
+		}
+	}
+	return r
+}
+
+// isAncestor reports whether the revision id is an ancestor of r. Past the
+// ancestors r lists, it follows the parents of versions this peer kept.
+func (m *merger) isAncestor(id string, r *revision) bool {
+	lineage := r.lineage()
+	for _, a := range lineage {
+		if a == id {
+			return true
+		}
+	}
+	if len(lineage) == 0 {
+		return false
+	}
+	for b := m.history[lineage[len(lineage)-1]]; b != nil && b.Rev != nil; b = m.history[b.Rev.Parent] {
+		if b.Rev.Parent == id {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeBase returns the nearest common ancestor of local and remote that
+// this peer kept, or nil.
+func (m *merger) mergeBase(local, remote *revision) *book {
+	remotes := make(map[string]bool)
+	for _, id := range remote.lineage() {
+		remotes[id] = true
+	}
+	for _, id := range local.lineage() {
+		if base, ok := m.history[id]; ok && remotes[id] {
+			return base
+		}
+	}
+	return nil
+}
+
+// observe records b as the latest version of its book. Only b and its
+// lineage are kept in history, as older versions can't be merge bases.
+func (m *merger) observe(b *book) {
+	c := *b
+	m.seen[b.ID] = &c
+	if b.Rev == nil {
+		return
+	}
+	needed := map[string]bool{b.Rev.ID: true}
+	for _, id := range b.Rev.lineage() {
+		needed[id] = true
+	}
+	kept := []string{b.Rev.ID}
+	for _, id := range m.kept[b.ID] {
+		switch {
+		case id == b.Rev.ID:
+		case needed[id]:
+			kept = append(kept, id)
+		default:
+			delete(m.history, id)
+		}
+	}
+	m.history[b.Rev.ID] = &c
+	m.kept[b.ID] = kept
+}
+
+// forget drops what this peer kept of a deleted book.
+func (m *merger) forget(id core.InstanceID) {
+	for _, rev := range m.kept[id] {
+		delete(m.history, rev)
+	}
+	delete(m.kept, id)
+	delete(m.seen, id)
+}
+
+// sameContent reports whether a and b are equal apart from their revisions.
+func sameContent(a, b *book) bool {
+	x, y := *a, *b
+	x.Rev, y.Rev = nil, nil
+	return reflect.DeepEqual(toDoc(&x), toDoc(&y))
+}
+
+func toDoc(b *book) map[string]interface{} {
+	doc := make(map[string]interface{})
+	_ = json.Unmarshal(util.JSONFromInstance(b), &doc)
+	return doc
+}
+
+// mergeDocs returns latest with every field that other changed from base,
+// and latest didn't, taken from other. Nested objects are merged per field.
+func mergeDocs(base, other, latest map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(latest))
+	for k, v := range latest {
+		out[k] = v
+	}
+	for k, ov := range other {
+		bv, lv := base[k], latest[k]
+		bm, bok := bv.(map[string]interface{})
+		om, ook := ov.(map[string]interface{})
+		lm, lok := lv.(map[string]interface{})
+		switch {
+		case bok && ook && lok:
+			out[k] = mergeDocs(bm, om, lm)
+		case !reflect.DeepEqual(ov, bv) && reflect.DeepEqual(lv, bv):
+			out[k] = ov
+		}
+	}
+	return out
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/textileio/go-threads/db"
+)
+
+func TestMergeLastWriterWins(t *testing.T) {
+	d, books := newTestBooks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := newMerger(d, books, lastWriterWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := m.run(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	b := &book{Title: "Title6", Author: "Author3", Meta: bookStats{Rating: 3}}
+	if err := m.Create(b); err != nil {
+		t.Fatal(err)
+	}
+	base := *b
+
+	// Another peer changed the rating from the same base while offline...
+	remote := base
+	remote.Meta.Rating = 2
+	remote.Rev = &revision{ID: "remote", Parent: base.Rev.ID, Time: base.Rev.Time + 1, Peer: "other"}
+	// ...before this peer did
+	b.Meta.Rating = 4
+	if err := m.Save(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Save(&remote); err != nil {
+		t.Fatal(err)
+	}
+
+	waitUntil(t, "the conflict to be logged", func() bool {
+		cs, err := m.Conflicts(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(cs) == 1
+	})
+	waitUntil(t, "the later rating to win", func() bool {
+		got, err := books.Get(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Meta.Rating == 4
+	})
+}
+
+func TestMergeAfterRestart(t *testing.T) {
+	d, books := newTestBooks(t)
+	before, err := newMerger(d, books, lastWriterWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &book{Title: "Title6", Author: "Author3", Meta: bookStats{Rating: 3}}
+	if err := before.Create(b); err != nil {
+		t.Fatal(err)
+	}
+	base := *b
+	b.Meta.Rating = 4
+	if err := before.Save(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart, an edit made elsewhere from the same base replicates
+	m, err := newMerger(d, books, lastWriterWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := base
+	remote.Meta.Rating = 2
+	remote.Rev = &revision{ID: "remote", Parent: base.Rev.ID, Time: base.Rev.Time + 1, Peer: "other"}
+	if err := books.Save(&remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.handleSave(b.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := m.Conflicts(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 {
+		t.Fatalf("the edit from before the restart should conflict, got %d logged", len(cs))
+	}
+	got, err := books.Get(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Meta.Rating != 4 {
+		t.Fatalf("the later local rating should win, got %v", got.Meta.Rating)
+	}
+}
+
+func TestMergeDescendant(t *testing.T) {
+	d, books := newTestBooks(t)
+	m, err := newMerger(d, books, lastWriterWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &book{Title: "Title6", Author: "Author3", Meta: bookStats{Rating: 3}}
+	if err := m.Create(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another peer saves twice, and both saves arrive before either is
+	// handled, so this peer never sees the first
+	ra := *b
+	ra.Meta.Rating = 4
+	ra.Rev = &revision{ID: "ra", Parent: b.Rev.ID, Time: b.Rev.Time + 1, Peer: "other"}
+	rb := ra
+	rb.Meta.Rating = 5
+	rb.Rev = &revision{ID: "rb", Parent: "ra", Ancestors: []string{b.Rev.ID}, Time: b.Rev.Time + 2, Peer: "other"}
+	for _, v := range []*book{&ra, &rb} {
+		if err := books.Save(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.handleSave(b.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cs, err := m.Conflicts(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 0 {
+		t.Fatalf("a descendant isn't a conflict, got %d logged", len(cs))
+	}
+	if got := m.seen[b.ID]; got.Rev.ID != "rb" {
+		t.Fatalf("rb should be the latest version seen, got %s", got.Rev.ID)
+	}
+}
+
+func TestMergePrunesHistory(t *testing.T) {
+	d, books := newTestBooks(t)
+	m, err := newMerger(d, books, lastWriterWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &book{Title: "Title6", Author: "Author3", Meta: bookStats{Rating: 3}}
+	if err := m.Create(b); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*maxAncestors; i++ {
+		b.Meta.TotalReads = i
+		if err := m.Save(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(m.history) > maxAncestors+2 {
+		t.Fatalf("history should keep at most %d versions, got %d", maxAncestors+2, len(m.history))
+	}
+
+	// A book deleted by another peer is forgotten when its save is handled
+	if err := books.Delete(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.handleSave(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.history) != 0 || len(m.seen) != 0 {
+		t.Fatalf("a deleted book should be forgotten, got %d versions", len(m.history))
+	}
+
+	// and one deleted on this peer right away
+	if err := m.Create(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := books.Get(b.ID); err != db.ErrInstanceNotFound || len(m.history) != 0 || len(m.seen) != 0 {
+		t.Fatalf("a deleted book should be gone and forgotten, got %v and %d versions", err, len(m.history))
+	}
+}
+
+func TestFieldMerge(t *testing.T) {
+	base := book{Title: "Title6", Author: "Author3", Meta: bookStats{Rating: 3}, Rev: &revision{ID: "base", Time: 1}}
+
+	// Field-level merge keeps both sides' changes
+	local := base
+	local.Meta.Rating = 5
+	other := base
+	other.Title = "Title7"
+	other.Rev = &revision{ID: "other", Parent: base.Rev.ID, Time: base.Rev.Time + 1}
+	merged := fieldMerge.Resolve(&base, &local, &other)
+	if merged.Title != "Title7" || merged.Meta.Rating != 5 {
+		t.Fatalf("fields should merge to Title7 rated 5, got %+v", merged)
+	}
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	keepLocal := registerStrategy("local", func(_, local, _ *book) *book { return local })
+	defer delete(mergeStrategies, "local")
+	if _, ok := mergeStrategies["local"]; !ok {
+		t.Fatal("a registered strategy should be selectable by name")
+	}
+
+	d, books := newTestBooks(t)
+	m, err := newMerger(d, books, keepLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &book{Title: "Title6", Author: "Author3", Meta: bookStats{Rating: 3}}
+	if err := m.Create(b); err != nil {
+		t.Fatal(err)
+	}
+	remote := *b
+	remote.Meta.Rating = 2
+	remote.Rev = &revision{ID: "remote", Parent: b.Rev.ID, Time: b.Rev.Time + 1, Peer: "other"}
+	b.Meta.Rating = 4
+	if err := m.Save(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Save(&remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.handleSave(b.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := books.Get(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Meta.Rating != 4 {
+		t.Fatalf("the custom strategy should keep the local rating, got %v", got.Meta.Rating)
+	}
+	cs, err := m.Conflicts(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 || cs[0].Strategy != "local" {
+		t.Fatalf("the conflict should be logged as resolved by local, got %+v", cs)
+	}
+}
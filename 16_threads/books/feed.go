@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+)
+
+// bookEvent is a change to the Book collection. Book is nil for deletes.
+// A watch with a filter sends "unmatch" when a save takes a book that
+// matched out of the filter, with the book as saved.
+type bookEvent struct {
+	Type string          `json:"type"`
+	ID   core.InstanceID `json:"id"`
+	Book *book           `json:"book,omitempty"`
+}
+
+var actionNames = map[db.ActionType]string{
+	db.ActionCreate: "create",
+	db.ActionSave:   "save",
+	db.ActionDelete: "delete",
+}
+
+// watchBooks streams changes to the books matching filter until ctx is done.
+// Changes made by other peers arrive once they are replicated locally.
+// A nil filter matches every book; a delete is sent if the book last matched.
+func watchBooks(ctx context.Context, d *db.DB, books *repository[book], filter func(*book) bool) (<-chan bookEvent, error) {
+	l, err := d.Listen(db.ListenOption{Collection: books.Collection().GetName()})
+	if err != nil {
+		return nil, err
+	}
+
+	// Books matching when the watch starts count as matched, so their
+	// deletes and unmatches are sent too
+	matched := make(map[core.InstanceID]bool)
+	if filter != nil {
+		existing, err := books.Find(&db.Query{})
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		for _, b := range existing {
+			if filter(b) {
+				matched[b.ID] = true
+			}
+		}
+	}
+
+	events := make(chan bookEvent)
+	go func() {
+		defer close(events)
+		defer l.Close()
+
+		for {
+			var a db.Action
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case a, ok = <-l.Channel():
+				if !ok {
+					return
+				}
+			}
+
+			e := bookEvent{Type: actionNames[a.Type], ID: a.ID}
+			if a.Type == db.ActionDelete {
+				if !matched[a.ID] && filter != nil {
+					continue
+				}
+				delete(matched, a.ID)
+			} else {
+				b, err := books.Get(a.ID)
+				if err != nil {
+					continue // Deleted since
+				}
+				e.Book = b
+				if filter != nil && !filter(b) {
+					if !matched[a.ID] {
+						continue
+					}
+					delete(matched, a.ID)
+					e.Type = "unmatch"
+				} else {
+					matched[a.ID] = true
+				}
+			}
+
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// bookFilter builds a watch filter from the author, title, minRating and
+// maxRating parameters, or nil if none are set.
+func bookFilter(params url.Values) (func(*book) bool, error) {
+	author := params.Get("author")
+	title := params.Get("title")
+	minRating, maxRating := -1.0, -1.0
+	for name, v := range map[string]*float64{"minRating": &minRating, "maxRating": &maxRating} {
+		s := params.Get(name)
+		if s == "" {
+			continue
+		}
+		rating, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, s)
+		}
+		*v = rating
+	}
+	if author == "" && title == "" && minRating < 0 && maxRating < 0 {
+		return nil, nil
+	}
+
+	return func(b *book) bool {
+		return (author == "" || b.Author == author) &&
+			(title == "" || b.Title == title) &&
+			(minRating < 0 || b.Meta.Rating >= minRating) &&
+			(maxRating < 0 || b.Meta.Rating <= maxRating)
+	}, nil
+}
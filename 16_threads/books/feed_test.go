@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-threads/db"
+)
+
+func TestWatchBooks(t *testing.T) {
+	d, books := newTestBooks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := watchBooks(ctx, d, books, func(b *book) bool { return b.Author == "Author1" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = books.Create(&book{Title: "Title4", Author: "Author2"}); err != nil {
+		t.Fatal(err)
+	}
+	book5 := &book{Title: "Title5", Author: "Author1"}
+	if _, err = books.Create(book5); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, events); e.Type != "create" || e.ID != book5.ID {
+		t.Fatalf("the first event should be creating Title5, got %+v", e)
+	}
+}
+
+func TestWatchBooksRemovals(t *testing.T) {
+	d, books := newTestBooks(t)
+	seedBooks(t, books)
+	title1, err := books.First(db.Where("Title").Eq("Title1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	title2, err := books.First(db.Where("Title").Eq("Title2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := watchBooks(ctx, d, books, func(b *book) bool { return b.Author == "Author1" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a book that matched before the watch started is sent
+	if err := books.Delete(title1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, events); e.Type != "delete" || e.ID != title1.ID {
+		t.Fatalf("deleting Title1 should be sent, got %+v", e)
+	}
+
+	// So is a save that takes a book out of the filter
+	title2.Author = "Author2"
+	if err := books.Save(title2); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, events); e.Type != "unmatch" || e.ID != title2.ID || e.Book.Author != "Author2" {
+		t.Fatalf("moving Title2 to Author2 should unmatch it, got %+v", e)
+	}
+}
+
+// nextEvent returns the next event, failing the test if none arrives.
+func nextEvent(t *testing.T, events <-chan bookEvent) bookEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("the event stream closed")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return bookEvent{}
+}
@@ -2,10 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/textileio/go-threads/common"
 	core "github.com/textileio/go-threads/core/db"
 	"github.com/textileio/go-threads/core/thread"
@@ -13,182 +24,293 @@ import (
 	"github.com/textileio/go-threads/util"
 )
 
+// defaultRepo is where the DB persists when serving, reopening a thread,
+// upgrading, exporting or importing without -repo.
+const defaultRepo = ".threads"
+
 type book struct {
 	ID     core.InstanceID `json:"_id"`
 	Title  string
 	Author string
 	Meta   bookStats
+	Rev    *revision `json:",omitempty"`
 }
 
 type bookStats struct {
 	TotalReads int
 	Rating     float64
+	Tags       []string `json:",omitempty"`
 }
 
 func main() {
-	d, clean := createMemDB()
-	defer clean()
-
-	collection, err := d.NewCollection(db.CollectionConfig{
-		Name:   "Book",
-		Schema: util.SchemaFromInstance(&book{}, false),
-	})
-	checkErr(err)
+	repo := flag.String("repo", "", "repo location the DB persists in across runs (default a temp dir, or "+defaultRepo+" with -serve, -thread, -upgrade, -export and -import)")
+	threadStr := flag.String("thread", "", "ID of the DB thread in the repo to reopen (default the last one used)")
+	apiAddr := flag.String("apiAddr", "127.0.0.1:8080", "API bind address used with -serve")
+	serve := flag.Bool("serve", false, "Serve the Book collection over HTTP from a persistent DB")
+	joinAddr := flag.String("join", "", "DB address to join on first run with -serve")
+	keyStr := flag.String("key", "", "DB key used with -join")
+	mergeName := flag.String("merge", "fields", "How -serve merges concurrent edits: none or a registered strategy ("+strings.Join(strategyNames(), ", ")+")")
+	upgrade := flag.Bool("upgrade", false, "Upgrade the Book collection to the latest schema and exit")
+	exportPath := flag.String("export", "", "Write every book in the repo to this JSON Lines file and exit")
+	importPath := flag.String("import", "", "Upsert the books in this JSON Lines file into the repo and exit")
+	flag.Parse()
 
-	// Bootstrap the collection with some books: two from Author1 and one from Author2
-	{
-		// Create a two books for Author1
-		book1 := &book{ // Notice ID will be autogenerated
-			Title:  "Title1",
-			Author: "Author1",
-			Meta:   bookStats{TotalReads: 100, Rating: 3.2},
+	var join ma.Multiaddr
+	var key thread.Key
+	if *joinAddr != "" {
+		if *upgrade {
+			checkErr(errors.New("-upgrade can't be used with -join: migrations are run by the peer that created the DB"))
 		}
-		book2 := &book{
-			Title:  "Title2",
-			Author: "Author1",
-			Meta:   bookStats{TotalReads: 150, Rating: 4.1},
-		}
-		_, err := collection.CreateMany([][]byte{util.JSONFromInstance(book1), util.JSONFromInstance(book2)})
+		var err error
+		join, err = ma.NewMultiaddr(*joinAddr)
 		checkErr(err)
-
-		// Create book for Author2
-		book3 := &book{
-			Title:  "Title3",
-			Author: "Author2",
-			Meta:   bookStats{TotalReads: 500, Rating: 4.9},
-		}
-		_, err = collection.Create(util.JSONFromInstance(book3))
+		key, err = thread.KeyFromString(*keyStr)
 		checkErr(err)
 	}
-
-	// Query all the books
-	{
-		books, err := collection.Find(&db.Query{})
+	id := thread.Undef
+	if *threadStr != "" {
+		var err error
+		id, err = thread.Decode(*threadStr)
 		checkErr(err)
-		if len(books) != 3 {
-			panic("there should be three books")
-		}
 	}
 
-	// Query the books from Author2
-	{
-		books, err := collection.Find(db.Where("Author").Eq("Author1"))
-		checkErr(err)
-		if len(books) != 2 {
-			panic("Author1 should have two books")
-		}
+	var d *db.DB
+	var clean func()
+	if *repo == "" && (*serve || id.Defined() || *upgrade || *exportPath != "" || *importPath != "") {
+		*repo = defaultRepo
 	}
+	if *repo != "" {
+		d, clean = createDB(*repo, id, join, key)
+	} else {
+		d, clean = createMemDB()
+	}
+	defer clean()
 
-	// Query with nested condition
-	{
-		books, err := collection.Find(db.Where("Meta.TotalReads").Eq(float64(100)))
+	m := bookMigrator(d)
+	var collection *db.Collection
+	if join != nil {
+		// Migrations are run by the peer that created the DB
+		collection = d.GetCollection("Book")
+	} else {
+		var err error
+		collection, err = m.upgrade()
 		checkErr(err)
-		if len(books) != 1 {
-			panic("There should be one book with 100 total reads")
+		if *upgrade {
+			fmt.Printf("Book collection at version %d\n", m.latest())
+			return
 		}
 	}
 
-	// Query book by two conditions
-	{
-		books, err := collection.Find(db.Where("Author").Eq("Author1").And("Title").Eq("Title2"))
+	switch {
+	case *exportPath != "":
+		f, err := os.Create(*exportPath)
 		checkErr(err)
-		if len(books) != 1 {
-			panic("Author1 should have only one book with Title2")
+		defer f.Close()
+		checkErr(exportCollection(f, collection, m))
+		return
+	case *importPath != "":
+		f, err := os.Open(*importPath)
+		checkErr(err)
+		defer f.Close()
+		report, err := importCollection(f, collection, m, bookValidators())
+		checkErr(err)
+		fmt.Printf("Created %d, updated %d, rejected %d\n", report.Created, report.Updated, len(report.Rejected))
+		for _, rej := range report.Rejected {
+			fmt.Printf("line %d %s: %s\n", rej.Line, rej.ID, strings.Join(rej.Errors, "; "))
 		}
+		return
 	}
 
-	// Query book by OR condition
-	{
-		books, err := collection.Find(db.Where("Author").Eq("Author1").Or(db.Where("Author").Eq("Author2")))
+	books := newRepository[book](collection, bookValidators()...)
+	if *serve {
+		addrs, key, err := d.GetDBInfo()
 		checkErr(err)
-		if len(books) != 3 {
-			panic("Author1 & Author2 have should have 3 books in total")
+		for _, addr := range addrs {
+			log.Printf("Join with -join %s -key %s\n", addr, key)
 		}
+		var m *merger
+		if *mergeName != "none" {
+			strategy, ok := mergeStrategies[*mergeName]
+			if !ok {
+				checkErr(fmt.Errorf("unknown merge strategy: %s", *mergeName))
+			}
+			m, err = newMerger(d, books, strategy)
+			checkErr(err)
+		}
+		checkErr(serveAPI(*apiAddr, d, books, m))
+		return
 	}
+	runExample(books)
+}
 
-	// Sorted query
-	{
-		// Ascending
-		res, err := collection.Find(db.Where("Author").Eq("Author1").OrderBy("Meta.TotalReads"))
+// serveAPI serves the books API until SIGINT or SIGTERM, merging concurrent
+// edits with m if it isn't nil.
+func serveAPI(addr string, d *db.DB, books *repository[book], m *merger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if m != nil {
+		go func() {
+			if err := m.run(ctx); err != nil {
+				log.Printf("error merging edits: %v\n", err)
+			}
+		}()
+	}
+
+	// Requests share ctx, so event streams end when shutdown starts instead
+	// of holding it up until the timeout
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     newBookAPI(d, books, m),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	log.Printf("Serving books API on %s\n", addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(sctx)
+	}
+}
+
+// runExample adds a few books to the collection, unless a previous run on
+// the same repo already did, and prints some queries over them. Books it
+// didn't add are left alone.
+func runExample(books *repository[book]) {
+	examples := []*book{
+		{Title: "Title1", Author: "Author1", Meta: bookStats{TotalReads: 100, Rating: 3.2}},
+		{Title: "Title2", Author: "Author1", Meta: bookStats{TotalReads: 150, Rating: 4.1}},
+		{Title: "Title3", Author: "Author2", Meta: bookStats{TotalReads: 500, Rating: 4.9}},
+	}
+	var missing []*book
+	for _, b := range examples {
+		res, err := books.FindBy("Title", b.Title)
 		checkErr(err)
-		books := make([]*book, len(res))
-		for i, item := range res {
-			book := &book{}
-			util.InstanceFromJSON(item, book)
-			books[i] = book
+		if len(res) == 0 {
+			missing = append(missing, b)
 		}
-		if books[0].Meta.TotalReads != 100 || books[1].Meta.TotalReads != 150 {
-			panic("books aren't ordered asc correctly")
-		}
-		// Descending
-		res, err = collection.Find(db.Where("Author").Eq("Author1").OrderByDesc("Meta.TotalReads"))
+	}
+	if len(missing) > 0 {
+		_, err := books.CreateMany(missing...)
 		checkErr(err)
-		books = make([]*book, len(res))
-		for i, item := range res {
-			book := &book{}
-			util.InstanceFromJSON(item, book)
-			books[i] = book
-		}
-		if books[0].Meta.TotalReads != 150 || books[1].Meta.TotalReads != 100 {
-			panic("books aren't ordered desc correctly")
-		}
 	}
+	fmt.Printf("Added %d example books, %d kept from a previous run\n", len(missing), len(examples)-len(missing))
 
-	// Query, Update, and Save
-	{
-		res, err := collection.Find(db.Where("Title").Eq("Title3"))
-		checkErr(err)
-		books := make([]*book, len(res))
-		for i, item := range res {
-			book := &book{}
-			util.InstanceFromJSON(item, book)
-			books[i] = book
+	// Count a read of Title3 in a transaction, so that a concurrent edit of
+	// it, such as one replicated from another peer, isn't overwritten
+	err := books.Update(func(tx *txn[book]) error {
+		b, err := tx.First(db.Where("Title").Eq("Title3"))
+		if err != nil {
+			return err
 		}
-
-		// Modify title
-		firstBook := books[0]
-		firstBook.Title = "ModifiedTitle"
-		_ = collection.Save(util.JSONFromInstance(firstBook))
-		res, err = collection.Find(db.Where("Title").Eq("Title3"))
+		b.Meta.TotalReads++
+		return tx.Save(b)
+	})
+	if errors.Is(err, errConflict) {
+		log.Println("Title3 kept changing, so its read wasn't counted")
+	} else {
 		checkErr(err)
-		if len(res) != 0 {
-			panic("Book with Title3 shouldn't exist")
-		}
+	}
+
+	res, err := books.FindSorted(db.Where("Author").Eq("Author1"), "Meta.TotalReads", true)
+	checkErr(err)
+	fmt.Printf("Author1's books, most read first: %v\n", titles(res))
+
+	res, err = books.Query(newFind(db.Where("Meta.Rating").Ge(4.0)).Prefix("Title", "Title"))
+	checkErr(err)
+	fmt.Printf("Rated 4 or more: %v\n", titles(res))
+
+	reads, err := books.Aggregate(newFind(nil), "Meta.TotalReads", "Author")
+	checkErr(err)
+	for _, a := range reads {
+		fmt.Printf("%s: %d books, %v reads\n", a.Group, a.Count, a.Sum)
+	}
+}
 
-		// Delete it
-		res, err = collection.Find(db.Where("Title").Eq("ModifiedTitle"))
+func titles(bs []*book) []string {
+	ts := make([]string, len(bs))
+	for i, b := range bs {
+		ts[i] = b.Title
+	}
+	return ts
+}
+
+// createDB opens the books DB persisted in repo, reopening the thread id or,
+// if undefined, the one last used in the repo. An id that isn't in the repo
+// is an error. If neither is set it joins the DB at join using key, or starts
+// a new one if join is nil.
+func createDB(repo string, id thread.ID, join ma.Multiaddr, key thread.Key) (*db.DB, func()) {
+	checkErr(os.MkdirAll(repo, os.ModePerm))
+	n, err := common.DefaultNetwork(repo, common.WithNetHostAddr(util.FreeLocalAddr()))
+	checkErr(err)
+	if !id.Defined() {
+		id, err = readThreadID(repo)
 		checkErr(err)
-		if len(res) != 1 {
-			panic("Book with ModifiedTitle should exist")
-		}
-		books = make([]*book, len(res))
-		for i, item := range res {
-			book := &book{}
-			util.InstanceFromJSON(item, book)
-			books[i] = book
+	}
+
+	ctx := context.Background()
+	var d *db.DB
+	switch {
+	case id.Defined():
+		// NewDB would start a new, empty DB for a thread that isn't there
+		if _, err := n.GetThread(ctx, id); err != nil {
+			checkErr(fmt.Errorf("thread %s isn't in repo %s: %w", id, repo, err))
 		}
-		_ = collection.Delete(books[0].ID)
-		res, err = collection.Find(db.Where("Title").Eq("ModifiedTitle"))
+		d, err = db.NewDB(ctx, n, id, db.WithNewDBRepoPath(repo))
+	case join != nil:
+		id, err = thread.FromAddr(join)
 		checkErr(err)
-		if len(res) != 0 {
-			panic("Book with ModifiedTitle shouldn't exist")
+		d, err = db.NewDBFromAddr(ctx, n, join, key,
+			db.WithNewDBRepoPath(repo),
+			db.WithNewDBCollections(append(bookMigrator(nil).collections(), conflictsConfig())...))
+	default:
+		id = thread.NewIDV1(thread.Raw, 32)
+		d, err = db.NewDB(ctx, n, id, db.WithNewDBRepoPath(repo))
+	}
+	checkErr(err)
+	checkErr(writeThreadID(repo, id))
+	return d, func() {
+		// Closing the DB first lets it finish dispatching pending events
+		// before the network they are written to goes away
+		if err := d.Close(); err != nil {
+			panic(err)
+		}
+		if err := n.Close(); err != nil {
+			panic(err)
 		}
 	}
 }
 
+// readThreadID returns the DB thread ID stored in repo, or thread.Undef.
+func readThreadID(repo string) (thread.ID, error) {
+	b, err := ioutil.ReadFile(filepath.Join(repo, "books.id"))
+	if os.IsNotExist(err) {
+		return thread.Undef, nil
+	}
+	if err != nil {
+		return thread.Undef, err
+	}
+	return thread.Decode(strings.TrimSpace(string(b)))
+}
+
+func writeThreadID(repo string, id thread.ID) error {
+	return ioutil.WriteFile(filepath.Join(repo, "books.id"), []byte(id.String()), 0600)
+}
+
+// createMemDB creates a new DB in a temp dir that is removed on close.
 func createMemDB() (*db.DB, func()) {
 	dir, err := ioutil.TempDir("", "")
 	checkErr(err)
-	n, err := common.DefaultNetwork(dir, common.WithNetDebug(true), common.WithNetHostAddr(util.FreeLocalAddr()))
-	checkErr(err)
-	id := thread.NewIDV1(thread.Raw, 32)
-	d, err := db.NewDB(context.Background(), n, id, db.WithNewDBRepoPath(dir))
-	checkErr(err)
+	d, closeDB := createDB(dir, thread.Undef, nil, thread.Key{})
 	return d, func() {
-		time.Sleep(time.Second) // Give threads a chance to finish work
-		if err := n.Close(); err != nil {
-			panic(err)
-		}
+		closeDB()
 		_ = os.RemoveAll(dir)
 	}
 }
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alecthomas/jsonschema"
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+	"github.com/textileio/go-threads/util"
+)
+
+// migrationCollection records the schema version applied to each collection.
+const migrationCollection = "Migration"
+
+// migration moves a collection to Version. Version 1 creates the collection,
+// later versions replace its schema and, if Transform is set, rewrite every
+// instance with it. Indexes replace the previous set; nil keeps it.
+type migration struct {
+	Version   int
+	Schema    *jsonschema.Schema
+	Indexes   []db.Index
+	Transform func(doc map[string]interface{}) error
+}
+
+type migrationRecord struct {
+	ID      core.InstanceID `json:"_id"`
+	Version int
+}
+
+// migrator applies registered migrations to a single collection.
+type migrator struct {
+	d          *db.DB
+	name       string
+	migrations []migration
+}
+
+// newMigrator returns a migrator for the named collection in d. d may be nil
+// when only the collection configs are needed.
+func newMigrator(d *db.DB, name string) *migrator {
+	return &migrator{d: d, name: name}
+}
+
+// register adds mg. Versions must be registered in order.
+func (m *migrator) register(mg migration) *migrator {
+	if mg.Version != len(m.migrations)+1 {
+		panic(fmt.Sprintf("migration %d registered out of order", mg.Version))
+	}
+	m.migrations = append(m.migrations, mg)
+	return m
+}
+
+// latest returns the highest registered version.
+func (m *migrator) latest() int {
+	return len(m.migrations)
+}
+
+// collections returns the configs of the collection at the latest version and
+// of the migration records, for peers joining an already migrated DB.
+func (m *migrator) collections() []db.CollectionConfig {
+	return []db.CollectionConfig{
+		recordsConfig(),
+		m.config(m.latest()),
+	}
+}
+
+// config returns the collection config as of version.
+func (m *migrator) config(version int) db.CollectionConfig {
+	config := db.CollectionConfig{Name: m.name, Schema: m.migrations[version-1].Schema}
+	for i := version - 1; i >= 0; i-- {
+		if m.migrations[i].Indexes != nil {
+			config.Indexes = m.migrations[i].Indexes
+			break
+		}
+	}
+	return config
+}
+
+// version returns the version currently applied to the collection.
+func (m *migrator) version() (int, error) {
+	records, err := m.records()
+	if err != nil {
+		return 0, err
+	}
+	raw, err := records.FindByID(core.InstanceID(m.name))
+	if err == db.ErrInstanceNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	rec := &migrationRecord{}
+	util.InstanceFromJSON(raw, rec)
+	return rec.Version, nil
+}
+
+// upgrade applies every pending migration in order and returns the collection
+// at the latest version.
+func (m *migrator) upgrade() (*db.Collection, error) {
+	current, err := m.version()
+	if err != nil {
+		return nil, err
+	}
+	if current > m.latest() {
+		return nil, fmt.Errorf("%s is at version %d, newer than %d", m.name, current, m.latest())
+	}
+	c := m.d.GetCollection(m.name)
+	for _, mg := range m.migrations[current:] {
+		if c, err = m.apply(c, mg); err != nil {
+			return nil, fmt.Errorf("migrating %s to version %d: %w", m.name, mg.Version, err)
+		}
+		if err = m.setVersion(mg.Version); err != nil {
+			return nil, err
+		}
+	}
+	if c == nil {
+		return nil, db.ErrCollectionNotFound
+	}
+	return c, nil
+}
+
+func (m *migrator) apply(c *db.Collection, mg migration) (*db.Collection, error) {
+	config := m.config(mg.Version)
+	if c == nil {
+		return m.d.NewCollection(config)
+	}
+	if mg.Transform == nil {
+		return m.d.UpdateCollection(config)
+	}
+
+	res, err := c.Find(&db.Query{})
+	if err != nil {
+		return nil, err
+	}
+	docs := make([][]byte, len(res))
+	for i, item := range res {
+		doc := make(map[string]interface{})
+		if err := json.Unmarshal(item, &doc); err != nil {
+			return nil, err
+		}
+		if err := mg.Transform(doc); err != nil {
+			return nil, fmt.Errorf("instance %v: %w", doc["_id"], err)
+		}
+		if docs[i], err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if c, err = m.d.UpdateCollection(config); err != nil {
+		return nil, err
+	}
+	if len(docs) > 0 {
+		if err = c.SaveMany(docs); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// transform rewrites doc, an instance at version from, with the transforms
+// of the later versions, as upgrading would.
+func (m *migrator) transform(doc map[string]interface{}, from int) error {
+	for _, mg := range m.migrations[from:] {
+		if mg.Transform == nil {
+			continue
+		}
+		if err := mg.Transform(doc); err != nil {
+			return fmt.Errorf("migrating to version %d: %w", mg.Version, err)
+		}
+	}
+	return nil
+}
+
+func (m *migrator) setVersion(version int) error {
+	records, err := m.records()
+	if err != nil {
+		return err
+	}
+	rec := util.JSONFromInstance(&migrationRecord{ID: core.InstanceID(m.name), Version: version})
+	exists, err := records.Has(core.InstanceID(m.name))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return records.Save(rec)
+	}
+	_, err = records.Create(rec)
+	return err
+}
+
+func (m *migrator) records() (*db.Collection, error) {
+	if c := m.d.GetCollection(migrationCollection); c != nil {
+		return c, nil
+	}
+	return m.d.NewCollection(recordsConfig())
+}
+
+func recordsConfig() db.CollectionConfig {
+	return db.CollectionConfig{
+		Name:   migrationCollection,
+		Schema: util.SchemaFromInstance(&migrationRecord{}, false),
+	}
+}
+
+// bookIndexes are the Book fields queried often enough to index.
+var bookIndexes = []db.Index{
+	{Path: "Author"},
+	{Path: "Meta.Rating"},
+}
+
+// bookV1 is the Book schema before tags were introduced.
+type bookV1 struct {
+	ID     core.InstanceID `json:"_id"`
+	Title  string
+	Author string
+	Meta   struct {
+		TotalReads int
+		Rating     float64
+	}
+}
+
+// bookV2 is the Book schema before revisions were introduced.
+type bookV2 struct {
+	ID     core.InstanceID `json:"_id"`
+	Title  string
+	Author string
+	Meta   bookStatsV2
+}
+
+// bookStatsV2 is Book.Meta as of schema versions 2 to 4.
+type bookStatsV2 struct {
+	TotalReads int
+	Rating     float64
+	Tags       []string `json:",omitempty"`
+}
+
+// bookV4 is the Book schema before revisions recorded their ancestors.
+type bookV4 struct {
+	ID     core.InstanceID `json:"_id"`
+	Title  string
+	Author string
+	Meta   bookStatsV2
+	Rev    *struct {
+		ID     string
+		Parent string `json:",omitempty"`
+		Time   int64
+		Peer   string
+	} `json:",omitempty"`
+}
+
+// bookMigrator returns the Book collection history. Append new versions,
+// never edit applied ones.
+func bookMigrator(d *db.DB) *migrator {
+	return newMigrator(d, "Book").
+		register(migration{
+			Version: 1,
+			Schema:  util.SchemaFromInstance(&bookV1{}, false),
+		}).
+		register(migration{
+			Version:   2,
+			Schema:    util.SchemaFromInstance(&bookV2{}, false),
+			Transform: addBookTags,
+		}).
+		register(migration{
+			Version: 3,
+			Schema:  util.SchemaFromInstance(&bookV2{}, false),
+			Indexes: bookIndexes,
+		}).
+		register(migration{
+			Version: 4,
+			Schema:  util.SchemaFromInstance(&bookV4{}, false),
+		}).
+		register(migration{
+			Version: 5,
+			Schema:  util.SchemaFromInstance(&book{}, false),
+		})
+}
+
+// addBookTags adds an empty Meta.Tags list to books that predate it.
+func addBookTags(doc map[string]interface{}) error {
+	meta, ok := doc["Meta"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("missing Meta")
+	}
+	if _, ok := meta["Tags"]; !ok {
+		meta["Tags"] = []interface{}{}
+	}
+	return nil
+}
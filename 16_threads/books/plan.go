@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/textileio/go-threads/db"
+)
+
+// queryPlan describes how a query is run against a collection.
+type queryPlan struct {
+	Index  string `json:"index,omitempty"`
+	Scan   bool   `json:"scan"`
+	Reason string `json:"reason"`
+}
+
+func (p queryPlan) String() string {
+	if p.Scan {
+		return "full scan: " + p.Reason
+	}
+	return fmt.Sprintf("index %s: %s", p.Index, p.Reason)
+}
+
+// planQuery picks an index for q and sets it with UseIndex. Equality
+// conditions are preferred over ranges; queries with OR conditions scan.
+func planQuery(q *db.Query, indexes []db.Index) queryPlan {
+	if q.Index != "" {
+		return queryPlan{Index: q.Index, Reason: "index set on query"}
+	}
+	if len(q.Ors) > 0 {
+		return queryPlan{Scan: true, Reason: "OR conditions can't use a single index"}
+	}
+
+	indexed := make(map[string]bool, len(indexes))
+	for _, idx := range indexes {
+		indexed[idx.Path] = true
+	}
+	var rangePath string
+	for _, c := range q.Ands {
+		if !indexed[c.FieldPath] {
+			continue
+		}
+		switch c.Operation {
+		case db.Eq:
+			q.UseIndex(c.FieldPath)
+			return queryPlan{Index: c.FieldPath, Reason: "equality on indexed field"}
+		case db.Gt, db.Ge, db.Lt, db.Le:
+			if rangePath == "" {
+				rangePath = c.FieldPath
+			}
+		}
+	}
+	if rangePath != "" {
+		q.UseIndex(rangePath)
+		return queryPlan{Index: rangePath, Reason: "range on indexed field"}
+	}
+	if len(q.Ands) == 0 {
+		return queryPlan{Scan: true, Reason: "no conditions"}
+	}
+	return queryPlan{Scan: true, Reason: "no condition on an indexed field"}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/textileio/go-threads/db"
+)
+
+// findQuery is a db.Query plus the operators threads DB doesn't run itself:
+// IN and text matches, skip/limit pagination and field projection. These are
+// applied to the results of the underlying query, in that order, so every
+// match of the db.Query is read and decoded even for a small page. Put the
+// most selective conditions, ideally on an indexed field, in the db.Query.
+type findQuery struct {
+	q       *db.Query
+	filters []func(doc map[string]interface{}) bool
+	skip    int
+	limit   int
+	fields  []string
+}
+
+// newFind wraps q. A nil q matches every instance.
+func newFind(q *db.Query) *findQuery {
+	if q == nil {
+		q = &db.Query{}
+	}
+	return &findQuery{q: q}
+}
+
+// In keeps instances whose field equals one of values.
+func (f *findQuery) In(field string, values ...interface{}) *findQuery {
+	want := make([]interface{}, len(values))
+	for i, v := range values {
+		want[i] = normalize(v)
+	}
+	return f.where(field, func(v interface{}) bool {
+		for _, w := range want {
+			if reflect.DeepEqual(v, w) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Prefix keeps instances whose string field starts with prefix.
+func (f *findQuery) Prefix(field, prefix string) *findQuery {
+	return f.where(field, func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, prefix)
+	})
+}
+
+// Contains keeps instances whose string field contains substr, ignoring case.
+func (f *findQuery) Contains(field, substr string) *findQuery {
+	substr = strings.ToLower(substr)
+	return f.where(field, func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && strings.Contains(strings.ToLower(s), substr)
+	})
+}
+
+// Skip drops the first n matches. A negative n is treated as zero.
+func (f *findQuery) Skip(n int) *findQuery {
+	if n < 0 {
+		n = 0
+	}
+	f.skip = n
+	return f
+}
+
+// Limit returns at most n matches. Zero or less means no limit.
+func (f *findQuery) Limit(n int) *findQuery {
+	if n < 0 {
+		n = 0
+	}
+	f.limit = n
+	return f
+}
+
+// Select keeps only fields, plus _id, in each match.
+func (f *findQuery) Select(fields ...string) *findQuery {
+	f.fields = fields
+	return f
+}
+
+func (f *findQuery) where(field string, match func(v interface{}) bool) *findQuery {
+	f.filters = append(f.filters, func(doc map[string]interface{}) bool {
+		v, ok := fieldValue(doc, field)
+		return ok && match(v)
+	})
+	return f
+}
+
+// run executes f on c, returning the page of matches and the number of
+// matches before pagination.
+func (f *findQuery) run(c *db.Collection) ([]map[string]interface{}, int, error) {
+	planQuery(f.q, c.GetIndexes())
+	res, err := c.Find(f.q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	docs := make([]map[string]interface{}, 0, len(res))
+next:
+	for _, item := range res {
+		doc := make(map[string]interface{})
+		if err := json.Unmarshal(item, &doc); err != nil {
+			return nil, 0, err
+		}
+		for _, match := range f.filters {
+			if !match(doc) {
+				continue next
+			}
+		}
+		docs = append(docs, doc)
+	}
+	total := len(docs)
+
+	if f.skip >= len(docs) {
+		docs = docs[:0]
+	} else {
+		docs = docs[f.skip:]
+	}
+	if f.limit > 0 && f.limit < len(docs) {
+		docs = docs[:f.limit]
+	}
+	if len(f.fields) > 0 {
+		for i, doc := range docs {
+			docs[i] = project(doc, f.fields)
+		}
+	}
+	return docs, total, nil
+}
+
+// fieldValue returns the value at a dotted path such as Meta.Rating.
+func fieldValue(doc map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// project copies _id and the fields at paths into a new document.
+func project(doc map[string]interface{}, paths []string) map[string]interface{} {
+	out := map[string]interface{}{"_id": doc["_id"]}
+	for _, path := range paths {
+		v, ok := fieldValue(doc, path)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(path, ".")
+		m := out
+		for _, part := range parts[:len(parts)-1] {
+			sub, ok := m[part].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				m[part] = sub
+			}
+			m = sub
+		}
+		m[parts[len(parts)-1]] = v
+	}
+	return out
+}
+
+// normalize converts v to the type it decodes to from JSON, so that an int
+// compares equal to the float64 stored for it.
+func normalize(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/textileio/go-threads/core/thread"
+	"github.com/textileio/go-threads/db"
+)
+
+// TestReplication starts two peers on localhost sharing one DB and checks
+// that creates, saves and deletes made on either peer converge.
+func TestReplication(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts two networked peers")
+	}
+	d1, books1 := newTestBooks(t)
+	addrs, key, err := d1.GetDBInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, clean2 := createDB(t.TempDir(), thread.Undef, addrs[0], key)
+	t.Cleanup(clean2)
+	books2 := newRepository[book](d2.GetCollection("Book"), bookValidators()...)
+
+	// Create on peer 1
+	b := &book{Title: "Title1", Author: "Author1", Meta: bookStats{TotalReads: 100, Rating: 3.2}}
+	if _, err := books1.Create(b); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "create to reach peer 2", func() bool {
+		_, err := books2.Get(b.ID)
+		return err == nil
+	})
+
+	// Save on peer 2
+	b.Meta.Rating = 4.5
+	if err := books2.Save(b); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "save to reach peer 1", func() bool {
+		got, err := books1.Get(b.ID)
+		return err == nil && got.Meta.Rating == 4.5
+	})
+
+	// Delete on peer 1
+	if err := books1.Delete(b.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "delete to reach peer 2", func() bool {
+		_, err := books2.Get(b.ID)
+		return err == db.ErrInstanceNotFound
+	})
+}
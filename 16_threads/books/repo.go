@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+	"github.com/textileio/go-threads/util"
+)
+
+// repository is a typed view over a collection. T must be a struct whose
+// instance ID field is tagged `json:"_id"`.
+// Writes are checked with validators first and fail with a *validationError.
+type repository[T any] struct {
+	c          *db.Collection
+	validators []validator
+}
+
+func newRepository[T any](c *db.Collection, validators ...validator) *repository[T] {
+	return &repository[T]{c: c, validators: validators}
+}
+
+// Collection returns the underlying collection.
+func (r *repository[T]) Collection() *db.Collection {
+	return r.c
+}
+
+// Create adds v to the collection and sets its generated instance ID.
+func (r *repository[T]) Create(v *T) (core.InstanceID, error) {
+	docs, err := encode(r.validators, v)
+	if err != nil {
+		return "", err
+	}
+	id, err := r.c.Create(docs[0])
+	if err != nil {
+		return "", err
+	}
+	return id, setID(v, id)
+}
+
+// CreateMany adds vs in a single transaction and sets their instance IDs.
+func (r *repository[T]) CreateMany(vs ...*T) ([]core.InstanceID, error) {
+	docs, err := encode(r.validators, vs...)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := r.c.CreateMany(docs)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if err := setID(vs[i], id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// Get returns the instance with id, or db.ErrInstanceNotFound.
+func (r *repository[T]) Get(id core.InstanceID) (*T, error) {
+	res, err := r.c.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	v := new(T)
+	util.InstanceFromJSON(res, v)
+	return v, nil
+}
+
+// Find returns every instance matching q, using an index when one applies.
+func (r *repository[T]) Find(q *db.Query) ([]*T, error) {
+	planQuery(q, r.c.GetIndexes())
+	res, err := r.c.Find(q)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[T](res), nil
+}
+
+// First returns the first instance matching q, or db.ErrInstanceNotFound.
+func (r *repository[T]) First(q *db.Query) (*T, error) {
+	vs, err := r.Find(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) == 0 {
+		return nil, db.ErrInstanceNotFound
+	}
+	return vs[0], nil
+}
+
+// Query runs f. Fields left out by Select are zero.
+func (r *repository[T]) Query(f *findQuery) ([]*T, error) {
+	docs, _, err := f.run(r.c)
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]*T, len(docs))
+	for i, doc := range docs {
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		vs[i] = new(T)
+		if err := json.Unmarshal(b, vs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return vs, nil
+}
+
+// QueryDocs runs f, returning the raw page of matches and the number of
+// matches before pagination.
+func (r *repository[T]) QueryDocs(f *findQuery) ([]map[string]interface{}, int, error) {
+	return f.run(r.c)
+}
+
+// Aggregate summarises the numeric field over the matches of f, grouped by
+// the groupBy path if set.
+func (r *repository[T]) Aggregate(f *findQuery, field, groupBy string) ([]aggregate, error) {
+	docs, _, err := f.run(r.c)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateDocs(docs, field, groupBy)
+}
+
+// Explain returns the plan Find uses for q, setting its index.
+func (r *repository[T]) Explain(q *db.Query) queryPlan {
+	return planQuery(q, r.c.GetIndexes())
+}
+
+// FindBy returns every instance whose field equals value.
+func (r *repository[T]) FindBy(field string, value interface{}) ([]*T, error) {
+	return r.Find(db.Where(field).Eq(value))
+}
+
+// FindSorted returns every instance matching q ordered by field.
+func (r *repository[T]) FindSorted(q *db.Query, field string, desc bool) ([]*T, error) {
+	if desc {
+		return r.Find(q.OrderByDesc(field))
+	}
+	return r.Find(q.OrderBy(field))
+}
+
+// Save replaces the stored instances with vs.
+func (r *repository[T]) Save(vs ...*T) error {
+	docs, err := encode(r.validators, vs...)
+	if err != nil {
+		return err
+	}
+	return r.c.SaveMany(docs)
+}
+
+// Delete removes the instances with ids.
+func (r *repository[T]) Delete(ids ...core.InstanceID) error {
+	return r.c.DeleteMany(ids)
+}
+
+// encode returns vs as JSON, checking each with validators.
+func encode[T any](validators []validator, vs ...*T) ([][]byte, error) {
+	docs := make([][]byte, len(vs))
+	for i, v := range vs {
+		docs[i] = util.JSONFromInstance(v)
+		if err := validate(docs[i], validators); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func decodeAll[T any](res [][]byte) []*T {
+	vs := make([]*T, len(res))
+	for i, item := range res {
+		v := new(T)
+		util.InstanceFromJSON(item, v)
+		vs[i] = v
+	}
+	return vs
+}
+
+// setID writes id into the field of v tagged `json:"_id"`, leaving the rest untouched.
+func setID(v interface{}, id core.InstanceID) error {
+	b, err := json.Marshal(map[string]core.InstanceID{"_id": id})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/textileio/go-threads/core/thread"
+	"github.com/textileio/go-threads/db"
+)
+
+// convergeTimeout is how long waitUntil waits, long enough for peers to
+// replicate a change.
+const convergeTimeout = time.Second * 30
+
+func TestRepository(t *testing.T) {
+	_, books := newTestBooks(t)
+	seedBooks(t, books)
+
+	queries := []struct {
+		name    string
+		query   *db.Query
+		find    *findQuery // Used instead of query when set
+		want    []string
+		ordered bool
+	}{
+		{
+			name:  "all the books",
+			query: &db.Query{},
+			want:  []string{"Title1", "Title2", "Title3"},
+		},
+		{
+			name:  "books from Author1",
+			query: db.Where("Author").Eq("Author1"),
+			want:  []string{"Title1", "Title2"},
+		},
+		{
+			name:  "nested condition",
+			query: db.Where("Meta.TotalReads").Eq(float64(100)),
+			want:  []string{"Title1"},
+		},
+		{
+			name:  "two conditions",
+			query: db.Where("Author").Eq("Author1").And("Title").Eq("Title2"),
+			want:  []string{"Title2"},
+		},
+		{
+			name:  "OR condition",
+			query: db.Where("Author").Eq("Author1").Or(db.Where("Author").Eq("Author2")),
+			want:  []string{"Title1", "Title2", "Title3"},
+		},
+		{
+			name:    "sorted ascending",
+			query:   db.Where("Author").Eq("Author1").OrderBy("Meta.TotalReads"),
+			want:    []string{"Title1", "Title2"},
+			ordered: true,
+		},
+		{
+			name:    "sorted descending",
+			query:   db.Where("Author").Eq("Author1").OrderByDesc("Meta.TotalReads"),
+			want:    []string{"Title2", "Title1"},
+			ordered: true,
+		},
+		{
+			name:  "rating range",
+			query: db.Where("Meta.Rating").Ge(4.0).And("Meta.Rating").Lt(4.5),
+			want:  []string{"Title2"},
+		},
+		{
+			name: "author IN",
+			find: newFind(nil).In("Author", "Author2", "Author3"),
+			want: []string{"Title3"},
+		},
+		{
+			name: "title prefix with rating",
+			find: newFind(db.Where("Meta.Rating").Ge(4.0)).Prefix("Title", "Title"),
+			want: []string{"Title2", "Title3"},
+		},
+		{
+			name: "title contains",
+			find: newFind(nil).Contains("Title", "le1"),
+			want: []string{"Title1"},
+		},
+		{
+			name:    "skip and limit",
+			find:    newFind((&db.Query{}).OrderBy("Meta.TotalReads")).Skip(1).Limit(1).Select("Title"),
+			want:    []string{"Title2"},
+			ordered: true,
+		},
+		{
+			name: "negative skip and limit",
+			find: newFind(nil).Skip(-1).Limit(-1),
+			want: []string{"Title1", "Title2", "Title3"},
+		},
+	}
+	for _, q := range queries {
+		t.Run(q.name, func(t *testing.T) {
+			var res []*book
+			var err error
+			if q.find != nil {
+				res, err = books.Query(q.find)
+			} else {
+				res, err = books.Find(q.query)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := titles(res)
+			if !q.ordered {
+				sort.Strings(got)
+			}
+			if !reflect.DeepEqual(got, q.want) {
+				t.Fatalf("got %v, want %v", got, q.want)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	_, books := newTestBooks(t)
+	seedBooks(t, books)
+
+	reads, err := books.Aggregate(newFind(nil), "Meta.TotalReads", "Author")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []aggregate{
+		{Group: "Author1", Count: 2, Sum: 250, Avg: 125, Min: 100, Max: 150},
+		{Group: "Author2", Count: 1, Sum: 500, Avg: 500, Min: 500, Max: 500},
+	}
+	if !reflect.DeepEqual(reads, want) {
+		t.Fatalf("reads per author: got %+v, want %+v", reads, want)
+	}
+
+	ratings, err := books.Aggregate(newFind(nil), "Meta.Rating", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ratings) != 1 || ratings[0].Count != 3 || math.Abs(ratings[0].Avg-4.0666) > 0.001 {
+		t.Fatalf("average rating should be 4.07, got %+v", ratings)
+	}
+}
+
+func TestProjection(t *testing.T) {
+	_, books := newTestBooks(t)
+	seedBooks(t, books)
+
+	res, err := books.Query(newFind(db.Where("Title").Eq("Title2")).Select("Title", "Meta.Rating"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].ID == "" || res[0].Author != "" || res[0].Meta.TotalReads != 0 || res[0].Meta.Rating != 4.1 {
+		t.Fatalf("projection should keep _id, Title and Meta.Rating, got %+v", res)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	_, books := newTestBooks(t)
+
+	if plan := books.Explain(db.Where("Author").Eq("Author1")); plan.Index != "Author" {
+		t.Fatalf("querying by Author should use its index, got %s", plan)
+	}
+	if plan := books.Explain(db.Where("Title").Eq("Title1").And("Meta.Rating").Ge(4.0)); plan.Index != "Meta.Rating" {
+		t.Fatalf("a rating range should use its index, got %s", plan)
+	}
+	if plan := books.Explain(db.Where("Author").Eq("Author1").Or(db.Where("Author").Eq("Author2"))); !plan.Scan {
+		t.Fatalf("an OR query should scan, got %s", plan)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	_, books := newTestBooks(t)
+
+	_, err := books.Create(&book{Title: " ", Author: "Author3", Meta: bookStats{Rating: 7}})
+	var verr *validationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("creating an invalid book should fail validation, got %v", err)
+	}
+	fields := make([]string, len(verr.Fields))
+	for i, f := range verr.Fields {
+		fields[i] = f.Field
+	}
+	if !reflect.DeepEqual(fields, []string{"Title", "Meta.Rating"}) {
+		t.Fatalf("Title and Meta.Rating should be invalid, got %v", verr)
+	}
+}
+
+// newTestDB returns a new DB in a temp dir that is removed after the test.
+func newTestDB(t testing.TB) *db.DB {
+	t.Helper()
+	d, clean := createDB(t.TempDir(), thread.Undef, nil, thread.Key{})
+	t.Cleanup(clean)
+	return d
+}
+
+// newTestBooks returns a new DB and its Book repository at the latest schema.
+func newTestBooks(t testing.TB) (*db.DB, *repository[book]) {
+	t.Helper()
+	d := newTestDB(t)
+	c, err := bookMigrator(d).upgrade()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, newRepository[book](c, bookValidators()...)
+}
+
+// seedBooks creates two books from Author1 and one from Author2.
+func seedBooks(t testing.TB, books *repository[book]) {
+	t.Helper()
+	_, err := books.CreateMany(
+		&book{Title: "Title1", Author: "Author1", Meta: bookStats{TotalReads: 100, Rating: 3.2}},
+		&book{Title: "Title2", Author: "Author1", Meta: bookStats{TotalReads: 150, Rating: 4.1}},
+		&book{Title: "Title3", Author: "Author2", Meta: bookStats{TotalReads: 500, Rating: 4.9}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitUntil polls cond until it holds, failing the test after
+// convergeTimeout.
+func waitUntil(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(convergeTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+	"github.com/textileio/go-threads/util"
+)
+
+// txnRetries is how many times Update reruns a transaction after a conflict.
+const txnRetries = 3
+
+// errConflict is returned when an instance read in a transaction was changed
+// by another writer, local or replicated, before the transaction committed.
+var errConflict = errors.New("transaction conflict")
+
+// txn is a typed transaction. Reads see committed state and are recorded,
+// and writes are buffered, so that commit can check the reads are still
+// current before applying the writes. Reads don't see the transaction's own
+// writes.
+type txn[T any] struct {
+	findByID   func(id core.InstanceID) ([]byte, error)
+	find       func(q *db.Query) ([][]byte, error)
+	readonly   bool
+	indexes    []db.Index
+	validators []validator
+	reads      map[core.InstanceID][]byte
+	writes     []func(dt *db.Txn) error
+}
+
+// Get returns the instance with id, or db.ErrInstanceNotFound.
+func (t *txn[T]) Get(id core.InstanceID) (*T, error) {
+	res, err := t.findByID(id)
+	if err == db.ErrInstanceNotFound {
+		t.record(id, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.record(id, res)
+	v := new(T)
+	util.InstanceFromJSON(res, v)
+	return v, nil
+}
+
+// Find returns every instance matching q. The instances returned are
+// recorded, not q, so one that starts matching q isn't a conflict.
+func (t *txn[T]) Find(q *db.Query) ([]*T, error) {
+	planQuery(q, t.indexes)
+	res, err := t.find(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range res {
+		t.record(docID(item), item)
+	}
+	return decodeAll[T](res), nil
+}
+
+// First returns the first instance matching q, or db.ErrInstanceNotFound.
+func (t *txn[T]) First(q *db.Query) (*T, error) {
+	vs, err := t.Find(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) == 0 {
+		return nil, db.ErrInstanceNotFound
+	}
+	return vs[0], nil
+}
+
+// Create adds vs and sets their instance IDs, generating those not set.
+func (t *txn[T]) Create(vs ...*T) ([]core.InstanceID, error) {
+	if t.readonly {
+		return nil, db.ErrReadonlyTx
+	}
+	ids := make([]core.InstanceID, len(vs))
+	for i, v := range vs {
+		if ids[i] = docID(util.JSONFromInstance(v)); ids[i] == core.EmptyInstanceID {
+			ids[i] = core.NewInstanceID()
+			if err := setID(v, ids[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	docs, err := encode(t.validators, vs...)
+	if err != nil {
+		return nil, err
+	}
+	t.writes = append(t.writes, func(dt *db.Txn) error {
+		_, err := dt.Create(docs...)
+		return err
+	})
+	return ids, nil
+}
+
+// Save replaces the stored instances with vs.
+func (t *txn[T]) Save(vs ...*T) error {
+	if t.readonly {
+		return db.ErrReadonlyTx
+	}
+	docs, err := encode(t.validators, vs...)
+	if err != nil {
+		return err
+	}
+	t.writes = append(t.writes, func(dt *db.Txn) error {
+		return dt.Save(docs...)
+	})
+	return nil
+}
+
+// Delete removes the instances with ids.
+func (t *txn[T]) Delete(ids ...core.InstanceID) error {
+	if t.readonly {
+		return db.ErrReadonlyTx
+	}
+	t.writes = append(t.writes, func(dt *db.Txn) error {
+		return dt.Delete(ids...)
+	})
+	return nil
+}
+
+func (t *txn[T]) record(id core.InstanceID, doc []byte) {
+	if _, ok := t.reads[id]; !ok {
+		t.reads[id] = doc
+	}
+}
+
+// commit applies the writes in dt if no instance read has changed since,
+// and otherwise returns errConflict.
+func (t *txn[T]) commit(dt *db.Txn) error {
+	for id, read := range t.reads {
+		current, err := dt.FindByID(id)
+		if err == db.ErrInstanceNotFound {
+			current = nil
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, read) {
+			return errConflict
+		}
+	}
+	for _, write := range t.writes {
+		if err := write(dt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update runs fn, then commits its writes together if nothing it read has
+// changed; otherwise none are. fn is rerun on conflict up to txnRetries
+// times, so it must not have side effects.
+//
+// The check and the writes run in one DB write transaction, which holds the
+// lock that other local writes and replicated events are applied under, so
+// no write can land between them. fn itself runs without the lock, so it
+// may read and write the DB outside the transaction.
+func (r *repository[T]) Update(fn func(t *txn[T]) error) error {
+	for i := 0; ; i++ {
+		t := r.newTxn(func(id core.InstanceID) ([]byte, error) {
+			return r.c.FindByID(id)
+		}, func(q *db.Query) ([][]byte, error) {
+			return r.c.Find(q)
+		}, false)
+		if err := fn(t); err != nil {
+			return err
+		}
+		err := r.c.WriteTxn(t.commit)
+		if err != errConflict || i == txnRetries {
+			return err
+		}
+	}
+}
+
+// View runs fn in a read-only transaction, which sees a consistent snapshot.
+// Writes in fn fail with db.ErrReadonlyTx.
+func (r *repository[T]) View(fn func(t *txn[T]) error) error {
+	return r.c.ReadTxn(func(dt *db.Txn) error {
+		return fn(r.newTxn(dt.FindByID, dt.Find, true))
+	})
+}
+
+func (r *repository[T]) newTxn(findByID func(core.InstanceID) ([]byte, error), find func(*db.Query) ([][]byte, error), readonly bool) *txn[T] {
+	return &txn[T]{
+		findByID:   findByID,
+		find:       find,
+		readonly:   readonly,
+		indexes:    r.c.GetIndexes(),
+		validators: r.validators,
+		reads:      make(map[core.InstanceID][]byte),
+	}
+}
+
+// docID returns the _id of a JSON instance.
+func docID(doc []byte) core.InstanceID {
+	var v struct {
+		ID core.InstanceID `json:"_id"`
+	}
+	util.InstanceFromJSON(doc, &v)
+	return v.ID
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	core "github.com/textileio/go-threads/core/db"
+	"github.com/textileio/go-threads/db"
+)
+
+func TestUpdate(t *testing.T) {
+	_, books := newTestBooks(t)
+	seedBooks(t, books)
+
+	// Query, Update, and Save in one transaction
+	var id core.InstanceID
+	err := books.Update(func(t *txn[book]) error {
+		b, err := t.First(db.Where("Title").Eq("Title3"))
+		if err != nil {
+			return err
+		}
+		b.Title = "ModifiedTitle"
+		id = b.ID
+		return t.Save(b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := books.FindBy("Title", "Title3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Fatal("Book with Title3 shouldn't exist")
+	}
+
+	// Delete it
+	err = books.Update(func(t *txn[book]) error {
+		b, err := t.Get(id)
+		if err != nil {
+			return err
+		}
+		if b.Title != "ModifiedTitle" {
+			return fmt.Errorf("book with ModifiedTitle should exist")
+		}
+		return t.Delete(id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = books.Get(id); err != db.ErrInstanceNotFound {
+		t.Fatalf("Book with ModifiedTitle shouldn't exist, got %v", err)
+	}
+}
+
+func TestUpdateAborts(t *testing.T) {
+	_, books := newTestBooks(t)
+
+	err := books.Update(func(t *txn[book]) error {
+		if _, err := t.Create(&book{Title: "Discarded", Author: "Author3"}); err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	if err == nil || err.Error() != "abort" {
+		t.Fatalf("the transaction should return its error, got %v", err)
+	}
+	res, err := books.FindBy("Title", "Discarded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Fatal("Book from a failed transaction shouldn't exist")
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	_, books := newTestBooks(t)
+	seedBooks(t, books)
+	original, err := books.First(db.Where("Title").Eq("Title1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another writer saves the book after the first attempt reads it, so
+	// the attempt conflicts and the retry sees the other write
+	attempts := 0
+	err = books.Update(func(tx *txn[book]) error {
+		attempts++
+		b, err := tx.Get(original.ID)
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			other := *b
+			other.Meta.TotalReads++
+			if err := books.Save(&other); err != nil {
+				return err
+			}
+		}
+		b.Meta.Rating = 5
+		return tx.Save(b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("the conflicting attempt should be retried once, got %d attempts", attempts)
+	}
+	got, err := books.Get(original.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Meta.TotalReads != original.Meta.TotalReads+1 || got.Meta.Rating != 5 {
+		t.Fatalf("both writes should be kept, got %+v", got.Meta)
+	}
+
+	// A conflict on every attempt is returned once the retries run out
+	attempts = 0
+	err = books.Update(func(tx *txn[book]) error {
+		attempts++
+		b, err := tx.Get(original.ID)
+		if err != nil {
+			return err
+		}
+		other := *b
+		other.Meta.TotalReads++
+		return books.Save(&other)
+	})
+	if err != errConflict {
+		t.Fatalf("should return errConflict, got %v", err)
+	}
+	if attempts != txnRetries+1 {
+		t.Fatalf("should make %d attempts, got %d", txnRetries+1, attempts)
+	}
+}
+
+func TestView(t *testing.T) {
+	_, books := newTestBooks(t)
+	seedBooks(t, books)
+
+	var got []*book
+	err := books.View(func(tx *txn[book]) error {
+		var err error
+		got, err = tx.Find(db.Where("Author").Eq("Author1").OrderBy("Meta.TotalReads"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := titles(got); len(ts) != 2 || ts[0] != "Title1" || ts[1] != "Title2" {
+		t.Fatalf("should read Title1 and Title2, got %v", ts)
+	}
+
+	err = books.View(func(tx *txn[book]) error {
+		return tx.Save(got[0])
+	})
+	if err != db.ErrReadonlyTx {
+		t.Fatalf("writes in a view should fail, got %v", err)
+	}
+}
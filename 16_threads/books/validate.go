@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// fieldError is a single validation failure.
+type fieldError struct {
+	Field    string      `json:"field"`
+	Expected string      `json:"expected"`
+	Value    interface{} `json:"value"`
+}
+
+func (e fieldError) String() string {
+	return fmt.Sprintf("%s: expected %s, got %v", e.Field, e.Expected, e.Value)
+}
+
+// validationError is returned by writes of instances that fail validation.
+type validationError struct {
+	Fields []fieldError `json:"fields"`
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.String()
+	}
+	return "invalid instance: " + strings.Join(msgs, "; ")
+}
+
+// validator checks an instance decoded from JSON.
+type validator func(doc map[string]interface{}) []fieldError
+
+// validate runs validators on a JSON instance, returning a *validationError
+// listing every failure.
+func validate(doc []byte, validators []validator) error {
+	if len(validators) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(doc, &m); err != nil {
+		return err
+	}
+	var fields []fieldError
+	for _, v := range validators {
+		fields = append(fields, v(m)...)
+	}
+	if len(fields) > 0 {
+		return &validationError{Fields: fields}
+	}
+	return nil
+}
+
+// schemaValidator checks instances against a collection schema.
+func schemaValidator(s *jsonschema.Schema) (validator, error) {
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(s))
+	if err != nil {
+		return nil, err
+	}
+	return func(doc map[string]interface{}) []fieldError {
+		res, err := schema.Validate(gojsonschema.NewGoLoader(doc))
+		if err != nil {
+			return []fieldError{{Field: "(root)", Expected: "a JSON object", Value: err.Error()}}
+		}
+		var fields []fieldError
+		for _, e := range res.Errors() {
+			expected := e.Description()
+			if t, ok := e.Details()["expected"]; ok {
+				expected = fmt.Sprint(t)
+			}
+			fields = append(fields, fieldError{Field: e.Field(), Expected: expected, Value: e.Value()})
+		}
+		return fields
+	}, nil
+}
+
+// rangeValidator checks that the number at field is within [min, max].
+func rangeValidator(field string, min, max float64) validator {
+	expected := fmt.Sprintf("a number from %v to %v", min, max)
+	return func(doc map[string]interface{}) []fieldError {
+		v, _ := fieldValue(doc, field)
+		n, ok := v.(float64)
+		if !ok || n < min || n > max {
+			return []fieldError{{Field: field, Expected: expected, Value: v}}
+		}
+		return nil
+	}
+}
+
+// nonEmptyValidator checks that the string at field isn't blank.
+func nonEmptyValidator(field string) validator {
+	return func(doc map[string]interface{}) []fieldError {
+		v, _ := fieldValue(doc, field)
+		s, ok := v.(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			return []fieldError{{Field: field, Expected: "a non-empty string", Value: v}}
+		}
+		return nil
+	}
+}
+
+// bookValidators returns the checks applied to every book write.
+func bookValidators() []validator {
+	m := bookMigrator(nil)
+	schema, err := schemaValidator(m.config(m.latest()).Schema)
+	checkErr(err)
+	return []validator{
+		schema,
+		nonEmptyValidator("Title"),
+		rangeValidator("Meta.Rating", 0, 5),
+	}
+}
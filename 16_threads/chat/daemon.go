@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-datastore/query"
+	"github.com/textileio/go-threads/core/thread"
+)
+
+const pullTimeout = time.Second * 30
+
+// recordCount is the number of records received since the daemon started.
+var recordCount uint64
+
+// runDaemon follows every named thread without starting the prompt.
+// It serves health and metrics on apiAddr and returns once ctx is cancelled.
+func runDaemon(apiAddr string, pullInterval time.Duration) error {
+	sub, err := net.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range sub {
+			atomic.AddUint64(&recordCount, 1)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	srv := &http.Server{Addr: apiAddr, Handler: mux}
+	errs := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+
+	log.Infof("daemon started, serving on %s", apiAddr)
+
+	pullThreads()
+	ticker := time.NewTicker(pullInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pullThreads()
+		case <-ctx.Done():
+			sctx, scancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer scancel()
+			return srv.Shutdown(sctx)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// pullThreads pulls every thread in /names once.
+func pullThreads() {
+	ids, err := threadIDs()
+	if err != nil {
+		log.Errorf("error listing threads: %s", err)
+		return
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		pctx, cancel := context.WithTimeout(ctx, pullTimeout)
+		if err := net.PullThread(pctx, id); err != nil {
+			log.Errorf("error pulling thread %s: %s", id, err)
+		}
+		cancel()
+	}
+}
+
+func threadIDs() (ids []thread.ID, err error) {
+	q, err := ds.Query(query.Query{Prefix: "/names"})
+	if err != nil {
+		return
+	}
+	all, err := q.Rest()
+	if err != nil {
+		return
+	}
+	for _, e := range all {
+		id, err := thread.Cast(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	state := "ok"
+	if ctx.Err() != nil {
+		status = http.StatusServiceUnavailable
+		state = "shutting down"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"status": state,
+		"peerID": net.Host().ID().String(),
+	})
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	ids, err := threadIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var logs int
+	for _, id := range ids {
+		info, err := net.GetThread(r.Context(), id)
+		if err != nil {
+			log.Errorf("error getting thread %s: %s", id, err)
+			continue
+		}
+		logs += len(info.Logs)
+	}
+
+	var b strings.Builder
+	writeMetric(&b, "chat_threads", "gauge", "Number of followed threads.", len(ids))
+	writeMetric(&b, "chat_logs", "gauge", "Number of logs across followed threads.", logs)
+	writeMetric(&b, "chat_records_received_total", "counter", "Number of records received since start.", atomic.LoadUint64(&recordCount))
+	writeMetric(&b, "chat_peers", "gauge", "Number of connected peers.", len(net.Host().Network().Peers()))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+func writeMetric(b *strings.Builder, name, kind, help string, value interface{}) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(b, "%s %v\n", name, value)
+}
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
@@ -32,6 +35,8 @@ import (
 
 var (
 	ctx      context.Context
+	cancel   context.CancelFunc
+	pending  sync.WaitGroup
 	ds       datastore.Batching
 	net      common.NetBoostrapper
 	threadID thread.ID
@@ -48,8 +53,9 @@ var (
 )
 
 const (
-	msgTimeout = time.Second * 10
-	timeLayout = "03:04:05 PM"
+	msgTimeout      = time.Second * 10
+	shutdownTimeout = time.Second * 10
+	timeLayout      = "03:04:05 PM"
 )
 
 func init() {
@@ -70,6 +76,9 @@ func main() {
 	repo := flag.String("repo", ".threads", "repo location")
 	hostAddrStr := flag.String("hostAddr", "/ip4/0.0.0.0/tcp/4006", "Threads host bind address")
 	debug := flag.Bool("debug", false, "Enable debug logging")
+	daemon := flag.Bool("daemon", false, "Run headless, following all threads without a prompt")
+	apiAddr := flag.String("apiAddr", "127.0.0.1:6006", "Daemon health and metrics bind address")
+	pullInterval := flag.Duration("pullInterval", time.Minute, "Daemon thread pull interval")
 	flag.Parse()
 
 	hostAddr, err := ma.NewMultiaddr(*hostAddrStr)
@@ -92,6 +101,11 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer func() {
+		if err := ds.Close(); err != nil {
+			log.Errorf("error closing datastore: %s", err)
+		}
+	}()
 
 	net, err = common.DefaultNetwork(
 		*repo,
@@ -100,17 +114,38 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer net.Close()
+	defer func() {
+		if err := net.Close(); err != nil {
+			log.Errorf("error closing network: %s", err)
+		}
+	}()
 	net.Bootstrap(util.DefaultBoostrapPeers())
 
+	// Cancelling ctx starts the shutdown, which unwinds the defers in order:
+	// pending writes, mDNS, network, and finally the datastore
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals()
+
 	// Build a MDNS service
-	ctx = context.Background()
 	mdns, err := discovery.NewMdnsService(ctx, net.Host(), time.Second, "")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer mdns.Close()
+	defer func() {
+		if err := mdns.Close(); err != nil {
+			log.Errorf("error closing mdns: %s", err)
+		}
+	}()
 	mdns.RegisterNotifee(&notifee{})
+	defer drain()
+
+	if *daemon {
+		if err := runDaemon(*apiAddr, *pullInterval); err != nil {
+			log.Error(err)
+		}
+		return
+	}
 
 	// Start the prompt
 	fmt.Println(grey("Welcome to Threads!"))
@@ -118,9 +153,14 @@ func main() {
 
 	sub, err := net.Subscribe(ctx)
 	if err != nil {
-		log.Fatal(err)
+		log.Error(err)
+		return
 	}
+	// Records touch the datastore, so shutdown waits for the subscription
+	// to close before the network and datastore are
+	pending.Add(1)
 	go func() {
+		defer pending.Done()
 		for rec := range sub {
 			name, err := threadName(rec.ThreadID().String())
 			if err != nil {
@@ -163,25 +203,80 @@ func main() {
 
 	log.Debug("chat started")
 
-	reader := bufio.NewReader(os.Stdin)
+	lines := make(chan string)
+	go readLines(lines)
 	for {
 		fmt.Print(cursor)
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			log.Fatal(err)
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return
+		case line, ok := <-lines:
+			if !ok {
+				cancel() // EOF
+				fmt.Println()
+				return
+			}
+			clean(1)
+
+			out, err := handleLine(line)
+			if err != nil {
+				logError(err)
+			}
+			if out != "" {
+				fmt.Println(grey(out))
+			}
 		}
-		clean(1)
+	}
+}
 
-		out, err := handleLine(line)
+// readLines sends stdin lines to the channel, closing it on EOF.
+func readLines(lines chan<- string) {
+	defer close(lines)
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadString('\n')
 		if err != nil {
-			logError(err)
+			if err != io.EOF {
+				log.Error(err)
+			}
+			return
 		}
-		if out != "" {
-			fmt.Println(grey(out))
+		select {
+		case lines <- line:
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
+// handleSignals cancels ctx on SIGINT or SIGTERM.
+func handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	select {
+	case sig := <-sigs:
+		log.Infof("received %s, shutting down", sig)
+		cancel()
+	case <-ctx.Done():
+	}
+}
+
+// drain waits for in-flight writes to finish, up to shutdownTimeout.
+func drain() {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Error("timed out waiting for pending writes")
+	}
+}
+
 func clean(lineCnt int) {
 	buf := bufio.NewWriter(os.Stdout)
 	_, _ = buf.Write([]byte("\033[J"))
@@ -210,6 +305,9 @@ func handleLine(line string) (out string, err error) {
 		switch cmds[0] {
 		case "help":
 			return cmdCmd()
+		case "quit":
+			cancel()
+			return
 		case "address":
 			if threadID.Defined() {
 				return threadAddressCmd(threadID)
@@ -286,6 +384,7 @@ func cmdCmd() (out string, err error) {
 	out += pink(":exit  ") + grey("Exit the active thread.\n")
 	out += pink(":keys  ") + grey("Show the active thread's keys.\n")
 	out += pink(":add-replicator <address>  ") + grey("Add a replicator at address to active thread.\n")
+	out += pink(":quit  ") + grey("Wait for pending messages and quit.\n")
 	out += pink("<message>  ") + grey("Send a message to the active thread.")
 	return
 }
@@ -384,7 +483,13 @@ func addCmd(args []string) (out string, err error) {
 			return "", err
 		}
 		id = info.ID
-		go net.PullThread(ctx, id)
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			if err := net.PullThread(ctx, id); err != nil && ctx.Err() == nil {
+				log.Errorf("error pulling thread: %s", err)
+			}
+		}()
 	} else {
 		th, err := net.CreateThread(ctx, thread.NewIDV1(thread.Raw, 32))
 		if err != nil {
@@ -521,14 +626,21 @@ func sendMessage(id thread.ID, txt string) error {
 		return fmt.Errorf("missing message")
 	}
 
+	if ctx.Err() != nil {
+		return fmt.Errorf("shutting down")
+	}
+
 	body, err := cbornode.WrapObject(&msg{Txt: txt}, mh.SHA2_256, -1)
 	if err != nil {
 		return err
 	}
+	pending.Add(1)
 	go func() {
-		mctx, cancel := context.WithTimeout(ctx, msgTimeout)
+		defer pending.Done()
+		// Not derived from ctx so that shutdown lets the write finish
+		mctx, cancel := context.WithTimeout(context.Background(), msgTimeout)
 		defer cancel()
-		if _, err = net.CreateRecord(mctx, id, body); err != nil {
+		if _, err := net.CreateRecord(mctx, id, body); err != nil {
 			log.Errorf("error writing message: %s", err)
 		}
 	}()